@@ -3,20 +3,31 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"iter"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/mmarchesotti/build-your-own-grep/internal/ahocorasick"
+	"github.com/mmarchesotti/build-your-own-grep/internal/ast"
+	"github.com/mmarchesotti/build-your-own-grep/internal/backtrack"
 	"github.com/mmarchesotti/build-your-own-grep/internal/buildnfa"
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
 	"github.com/mmarchesotti/build-your-own-grep/internal/lexer"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfa"
 	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
 	"github.com/mmarchesotti/build-your-own-grep/internal/parser"
+	"github.com/mmarchesotti/build-your-own-grep/internal/token"
 )
 
 const usage = `Usage: mygrep [options] <pattern> [path...]
+       mygrep [options] -e PATTERN... | -f FILE... [path...]
 
 Search for PATTERN in each PATH. If no PATH is provided,
 the search reads from standard input.
@@ -24,25 +35,82 @@ the search reads from standard input.
 Options:
   -r    Recursively search subdirectories. When this flag is used,
         the trailing path must be a single directory.
+  -g GROUP
+        Print only the text captured by GROUP, given as a group
+        number or as the name of a (?P<name>...) group.
+  -o    Print only the matched parts of a line, each on its own
+        output line.
+  -X    Verbose pattern: ignore unescaped whitespace and # comments
+        in PATTERN, as if it started with (?x).
+  -E    Read PATTERN as an extended regular expression (the default).
+  -G    Read PATTERN as a basic regular expression, where \( \)
+        \{ \} and \| are operators and ( ) { } | + ? are literals.
+  -F    Read PATTERN as a list of fixed strings, one per line, any
+        of which may match.
+        When several of -E, -G and -F are given, the last one wins.
+  -e PATTERN
+        Search for PATTERN. May be repeated to search for any of
+        several patterns; the pattern is then not given positionally.
+  -f FILE
+        Search for the patterns in FILE, one per line. An empty line
+        matches every line. May be repeated and combined with -e.
+  -p    Prefix each output line with the number of the pattern that
+        matched, counting from 1 in the order of -e and -f.
 
 Examples:
   mygrep 'apple' file1.txt file2.txt
   cat file.txt | mygrep 'apple'
-  mygrep -r 'apple' ./my_project`
+  mygrep -r 'apple' ./my_project
+  mygrep -g level '(?P<level>ERROR|WARN): ' app.log`
 
 func main() {
 	recursive := flag.Bool("r", false, "Recursive search")
+	group := flag.String("g", "", "Print only the text captured by this group")
+	onlyMatching := flag.Bool("o", false, "Print only the matched parts of each line")
+	verbose := flag.Bool("X", false, "Ignore whitespace and # comments in the pattern")
+	selectedDialect := dialectExtended
+	flag.Var(dialectFlag{&selectedDialect, dialectExtended}, "E", "Read the pattern as an extended regular expression")
+	flag.Var(dialectFlag{&selectedDialect, dialectBasic}, "G", "Read the pattern as a basic regular expression")
+	flag.Var(dialectFlag{&selectedDialect, dialectFixed}, "F", "Read the pattern as a list of fixed strings")
+	var patterns []string
+	patternGiven := false
+	flag.Var(patternFlag{&patterns, &patternGiven}, "e", "Search for this pattern")
+	flag.Var(patternFileFlag{&patterns, &patternGiven}, "f", "Search for the patterns in this file")
+	patternNumber := flag.Bool("p", false, "Prefix each output line with the number of the pattern that matched")
 	flag.Parse()
 
 	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "error: missing pattern")
-		fmt.Fprintln(os.Stderr, usage)
-		os.Exit(2)
+	if !patternGiven {
+		if len(args) < 1 {
+			fmt.Fprintln(os.Stderr, "error: missing pattern")
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		patterns = args[:1]
+		args = args[1:]
+	}
+
+	paths := args
+	options := outputOptions{group: *group, onlyMatching: *onlyMatching, patternNumber: *patternNumber}
+
+	compile := compileOptions{basic: selectedDialect == dialectBasic, fixed: selectedDialect == dialectFixed}
+	if *verbose {
+		compile.flags |= token.FlagExtended
 	}
 
-	pattern := args[0]
-	paths := args[1:]
+	// The pattern is compiled before any input is read, so that an
+	// invalid one is reported even when there is nothing to search.
+	compiled, err := compilePatterns(patterns, compile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, formatError(err, patterns))
+		os.Exit(2)
+	}
+	if options.group != "" {
+		if err := compiled.checkGroup(options.group); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+	}
 
 	matchFound := false
 	var filenames []string
@@ -75,9 +143,9 @@ func main() {
 	}
 
 	if len(filenames) == 0 {
-		hasMatch, matchedLines, err := processLines(os.Stdin, pattern)
+		hasMatch, matchedLines, err := processLines(os.Stdin, compiled, options)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			fmt.Fprintln(os.Stderr, formatError(err, patterns))
 			os.Exit(2)
 		}
 		matchFound = hasMatch
@@ -96,9 +164,9 @@ func main() {
 			}
 			defer file.Close()
 
-			hasMatch, matchedLines, err := processLines(file, pattern)
+			hasMatch, matchedLines, err := processLines(file, compiled, options)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				fmt.Fprintln(os.Stderr, formatError(err, patterns))
 				os.Exit(2)
 			}
 			matchFound = matchFound || hasMatch
@@ -118,24 +186,188 @@ func main() {
 	}
 }
 
-func processLines(input io.Reader, pattern string) (bool, [][]byte, error) {
+// patternFlag is the -e flag, which adds its value to patterns.
+type patternFlag struct {
+	patterns *[]string
+	given    *bool
+}
+
+func (f patternFlag) String() string {
+	return ""
+}
+
+func (f patternFlag) Set(value string) error {
+	*f.patterns = append(*f.patterns, value)
+	*f.given = true
+	return nil
+}
+
+// patternFileFlag is the -f flag, which adds each line of the file it
+// names to patterns. An empty file adds no pattern, and so matches
+// nothing.
+type patternFileFlag struct {
+	patterns *[]string
+	given    *bool
+}
+
+func (f patternFileFlag) String() string {
+	return ""
+}
+
+func (f patternFileFlag) Set(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*f.given = true
+	if len(content) == 0 {
+		return nil
+	}
+	text := strings.TrimSuffix(string(content), "\n")
+	*f.patterns = append(*f.patterns, strings.Split(text, "\n")...)
+	return nil
+}
+
+// dialect is the syntax in which patterns are read.
+type dialect int
+
+const (
+	dialectExtended dialect = iota
+	dialectBasic
+	dialectFixed
+)
+
+// dialectFlag is one of the -E, -G and -F flags, which selects its
+// dialect. As in grep, when several are given the last one wins.
+type dialectFlag struct {
+	selected *dialect
+	dialect  dialect
+}
+
+func (f dialectFlag) String() string {
+	return ""
+}
+
+func (f dialectFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		*f.selected = f.dialect
+	}
+	return nil
+}
+
+func (f dialectFlag) IsBoolFlag() bool {
+	return true
+}
+
+// formatError describes err, an error from searching for patterns, for
+// the user. Each error located in a pattern is followed by the pattern
+// itself, with a caret under the offending text. When there are several
+// patterns, the number of the one at fault is given too.
+func formatError(err error, patterns []string) string {
+	var errs diagnostic.ErrorList
+	if !errors.As(err, &errs) {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	prefix := ""
+	pattern := ""
+	if len(patterns) > 0 {
+		pattern = patterns[0]
+	}
+	var setErr *patternSetError
+	if errors.As(err, &setErr) {
+		prefix = fmt.Sprintf("pattern %d: ", setErr.index+1)
+		pattern = patterns[setErr.index]
+	}
+
+	var out strings.Builder
+	for i, patternErr := range errs {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "error: %s%s\n", prefix, patternErr.Message)
+		caret := diagnostic.Caret(pattern, patternErr)
+		out.WriteString("  " + strings.ReplaceAll(caret, "\n", "\n  "))
+	}
+	return out.String()
+}
+
+// compileOptions controls how a pattern is read.
+type compileOptions struct {
+	// flags are turned on for the whole pattern.
+	flags token.Flags
+	// basic reads the pattern as a POSIX basic regular expression instead
+	// of an extended one.
+	basic bool
+	// fixed reads the pattern as a newline-separated list of literal
+	// strings, searched for without going through the regex engines.
+	fixed bool
+}
+
+// outputOptions controls what is printed for each matching line.
+type outputOptions struct {
+	// group selects a capture group, by number or name, whose text is
+	// printed instead of the whole line.
+	group string
+	// onlyMatching prints every non-empty match in the line on its own
+	// output line instead of printing the line once.
+	onlyMatching bool
+	// patternNumber prefixes each output line with the number, counting
+	// from 1, of the pattern whose match produced it.
+	patternNumber bool
+}
+
+// processLines returns the output produced for the lines of input that
+// match pattern, as selected by options.
+func processLines(input io.Reader, pattern *compiledPattern, options outputOptions) (bool, [][]byte, error) {
 	scanner := bufio.NewScanner(input)
 	anyMatchFound := false
 
+	group := options.group
+	if group == "" && options.onlyMatching {
+		group = "0"
+	}
+
 	var matchedLines [][]byte
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		lineCopy := make([]byte, len(line))
 		copy(lineCopy, line)
 
-		ok, err := matchLine(lineCopy, pattern)
+		results, err := pattern.matches(lineCopy)
 		if err != nil {
 			return false, nil, err
 		}
 
-		if ok {
+		for result := range results {
 			anyMatchFound = true
-			matchedLines = append(matchedLines, lineCopy)
+
+			prefix := func(text []byte) []byte {
+				if !options.patternNumber {
+					return text
+				}
+				return append([]byte(strconv.Itoa(result.Pattern+1)+":"), text...)
+			}
+
+			if group == "" {
+				matchedLines = append(matchedLines, prefix(lineCopy))
+				break
+			}
+			capture, err := selectGroup(result, group)
+			if err != nil {
+				return false, nil, err
+			}
+			participated := capture.Start != -1 && capture.End != -1
+			if participated && (!options.onlyMatching || capture.End > capture.Start) {
+				matchedLines = append(matchedLines, prefix(lineCopy[capture.Start:capture.End]))
+			}
+			if !options.onlyMatching {
+				break
+			}
 		}
 	}
 
@@ -146,28 +378,238 @@ func processLines(input io.Reader, pattern string) (bool, [][]byte, error) {
 	return anyMatchFound, matchedLines, nil
 }
 
-func matchLine(lineCopy []byte, pattern string) (bool, error) {
-	tokens, tokenizeErr := lexer.Tokenize(pattern)
-	if tokenizeErr != nil {
-		return false, tokenizeErr
+// selectGroup returns the capture of result identified by group, which is
+// either a group number or a group name.
+func selectGroup(result nfasimulator.MatchResult, group string) (nfasimulator.Capture, error) {
+	if groupIndex, err := strconv.Atoi(group); err == nil {
+		if groupIndex < 0 || groupIndex >= len(result.Captures) {
+			return nfasimulator.Capture{}, fmt.Errorf("unknown capture group %s", group)
+		}
+		return result.Captures[groupIndex], nil
+	}
+	capture, ok := result.Named(group)
+	if !ok {
+		return nfasimulator.Capture{}, fmt.Errorf("unknown capture group %s", group)
+	}
+	return capture, nil
+}
+
+// compiledPattern holds the patterns searched for, compiled once before
+// any input is read and then matched against every line.
+type compiledPattern struct {
+	// patternCount is the number of patterns compiled together.
+	patternCount int
+
+	// Regex patterns are compiled into a single NFA, run by simulate.
+	fragment     nfa.Fragment
+	captureCount int
+	groupNames   map[string]int
+	simulate     func([]byte, nfa.Fragment, int, map[string]int) (iter.Seq[nfasimulator.MatchResult], error)
+
+	// Fixed strings are compiled into automaton instead. literalPatterns
+	// holds the index of the pattern each of its strings came from.
+	automaton       *ahocorasick.Automaton
+	literalPatterns []int
+}
+
+// compilePatterns compiles patterns as set by options.
+// Patterns with back-references are run by the backtracking engine;
+// everything else goes through the NFA simulator.
+func compilePatterns(patterns []string, options compileOptions) (*compiledPattern, error) {
+	compiled := &compiledPattern{patternCount: len(patterns)}
+
+	if options.fixed {
+		// A pattern may hold several strings, one per line, which all
+		// report the pattern's own number.
+		var literals []string
+		for i, pattern := range patterns {
+			for _, literal := range strings.Split(pattern, "\n") {
+				literals = append(literals, literal)
+				compiled.literalPatterns = append(compiled.literalPatterns, i)
+			}
+		}
+		compiled.automaton = ahocorasick.New(literals)
+		return compiled, nil
+	}
+	if len(patterns) == 0 {
+		return compiled, nil
 	}
 
-	tree, captureCount, parseErr := parser.Parse(tokens)
+	tree, captureCount, groupNames, parseErr := parsePatterns(patterns, options)
 	if parseErr != nil {
-		return false, parseErr
+		return nil, parseErr
 	}
 
 	fragment, buildErr := buildnfa.Build(tree)
 	if buildErr != nil {
-		return false, buildErr
+		return nil, buildErr
 	}
 
-	captures, simulationErr := nfasimulator.Simulate(lineCopy, fragment, captureCount)
+	compiled.fragment = fragment
+	compiled.captureCount = captureCount
+	compiled.groupNames = groupNames
+	compiled.simulate = nfasimulator.Simulate
+	if ast.HasBackReference(tree) {
+		compiled.simulate = backtrack.Simulate
+	}
+	return compiled, nil
+}
+
+// checkGroup returns an error if group, a group number or name as given
+// to -g, identifies none of the capture groups of the patterns.
+func (p *compiledPattern) checkGroup(group string) error {
+	// Fixed strings have only the whole match, and several patterns have
+	// the groups that tell them apart besides their own; see
+	// parsePatterns.
+	groupCount := 1
+	if p.simulate != nil {
+		groupCount = p.captureCount
+		if p.patternCount > 1 {
+			groupCount -= p.patternCount
+		}
+	}
+
+	if groupIndex, err := strconv.Atoi(group); err == nil {
+		if groupIndex < 0 || groupIndex >= groupCount {
+			return fmt.Errorf("unknown capture group %s", group)
+		}
+		return nil
+	}
+	if _, ok := p.groupNames[group]; !ok {
+		return fmt.Errorf("unknown capture group %s", group)
+	}
+	return nil
+}
+
+// matches returns the successive matches in line of any of the patterns.
+func (p *compiledPattern) matches(line []byte) (iter.Seq[nfasimulator.MatchResult], error) {
+	if p.automaton != nil {
+		return p.fixedMatches(line), nil
+	}
+	if p.simulate == nil {
+		// There are no patterns, so nothing matches.
+		return func(yield func(nfasimulator.MatchResult) bool) {}, nil
+	}
+
+	results, simulationErr := p.simulate(line, p.fragment, p.captureCount, p.groupNames)
 	if simulationErr != nil {
-		return false, fmt.Errorf("invalid pattern: %w", simulationErr)
+		return nil, fmt.Errorf("invalid pattern: %w", simulationErr)
+	}
+
+	if p.patternCount > 1 {
+		return identifyPatterns(results, p.patternCount), nil
 	}
+	return results, nil
+}
+
+// fixedMatches returns the matches of the automaton in line in the form
+// the regex engines use: the position of each match is its group 0, and
+// its Pattern is that of the string that matched.
+func (p *compiledPattern) fixedMatches(line []byte) iter.Seq[nfasimulator.MatchResult] {
+	return func(yield func(nfasimulator.MatchResult) bool) {
+		for _, match := range p.automaton.FindAll(line) {
+			result := nfasimulator.MatchResult{
+				Captures: []nfasimulator.Capture{{Start: match.Start, End: match.End}},
+				Pattern:  p.literalPatterns[match.Pattern],
+			}
+			if !yield(result) {
+				return
+			}
+		}
+	}
+}
+
+// patternSetError is an error in the pattern with the given index among
+// several searched for together.
+type patternSetError struct {
+	index int
+	err   error
+}
+
+func (e *patternSetError) Error() string {
+	return fmt.Sprintf("pattern %d: %v", e.index+1, e.err)
+}
+
+func (e *patternSetError) Unwrap() error {
+	return e.err
+}
 
-	_, hasMatch := <-captures
+// parsePatterns parses patterns as set by compile into a single tree that
+// matches wherever any of them does, and returns it along with its number
+// of capture groups and its group names as parser.Parse does.
+//
+// Each pattern is parsed on its own, so its flags, group numbers and
+// back-references are unaffected by the others. Its groups are then
+// renumbered to follow those of the patterns before it, and the whole of it
+// is captured by a group numbered after all of those, which tells which
+// pattern a match belongs to; see identifyPatterns. A group name used by
+// more than one pattern refers to the first of them.
+func parsePatterns(patterns []string, compile compileOptions) (ast.ASTNode, int, map[string]int, error) {
+	tokenize := lexer.TokenizeWithFlags
+	if compile.basic {
+		tokenize = lexer.TokenizeBasic
+	}
+	if len(patterns) == 1 {
+		tokens, tokenizeErr := tokenize(patterns[0], compile.flags)
+		if tokenizeErr != nil {
+			return nil, 0, nil, tokenizeErr
+		}
+		return parser.Parse(tokens)
+	}
 
-	return hasMatch, nil
+	captureCount := 1
+	groupNames := make(map[string]int)
+	branches := make([]ast.ASTNode, len(patterns))
+	for i, pattern := range patterns {
+		tokens, tokenizeErr := tokenize(pattern, compile.flags)
+		if tokenizeErr != nil {
+			return nil, 0, nil, &patternSetError{index: i, err: tokenizeErr}
+		}
+		tree, patternCaptureCount, patternGroupNames, parseErr := parser.Parse(tokens)
+		if parseErr != nil {
+			return nil, 0, nil, &patternSetError{index: i, err: parseErr}
+		}
+
+		offset := captureCount - 1
+		ast.ShiftGroups(tree, offset)
+		for name, groupIndex := range patternGroupNames {
+			if _, ok := groupNames[name]; !ok {
+				groupNames[name] = groupIndex + offset
+			}
+		}
+		captureCount += patternCaptureCount - 1
+		branches[i] = tree
+	}
+
+	var tree ast.ASTNode
+	for i := len(branches) - 1; i >= 0; i-- {
+		var branch ast.ASTNode = &ast.CaptureGroupNode{Child: branches[i], GroupIndex: captureCount + i}
+		if tree != nil {
+			branch = &ast.AlternationNode{Left: branch, Right: tree}
+		}
+		tree = branch
+	}
+	return tree, captureCount + len(patterns), groupNames, nil
+}
+
+// identifyPatterns passes on the results of matching a tree built by
+// parsePatterns from patternCount patterns, with the Pattern of each set
+// from the group that tells which pattern matched and with that group
+// dropped from its captures.
+func identifyPatterns(results iter.Seq[nfasimulator.MatchResult], patternCount int) iter.Seq[nfasimulator.MatchResult] {
+	return func(yield func(nfasimulator.MatchResult) bool) {
+		for result := range results {
+			groupCount := len(result.Captures) - patternCount
+			for i, capture := range result.Captures[groupCount:] {
+				if capture.Start != -1 {
+					result.Pattern = i
+					break
+				}
+			}
+			result.Captures = result.Captures[:groupCount]
+			if !yield(result) {
+				return
+			}
+		}
+	}
 }
@@ -2,14 +2,49 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
+	"github.com/mmarchesotti/build-your-own-grep/internal/token"
 )
 
+// mustCompile compiles patterns as set by compile, failing the test if
+// they are invalid.
+func mustCompile(t *testing.T, patterns []string, compile compileOptions) *compiledPattern {
+	t.Helper()
+	compiled, err := compilePatterns(patterns, compile)
+	if err != nil {
+		t.Fatalf("compilePatterns returned an unexpected error: %v", err)
+	}
+	return compiled
+}
+
+// findMatch compiles pattern and returns its first match in line.
+func findMatch(t *testing.T, line []byte, pattern string) (nfasimulator.MatchResult, bool) {
+	t.Helper()
+	results, err := mustCompile(t, []string{pattern}, compileOptions{}).matches(line)
+	if err != nil {
+		t.Fatalf("matches returned an unexpected error: %v", err)
+	}
+	for result := range results {
+		return result, true
+	}
+	return nfasimulator.MatchResult{}, false
+}
+
+// matchLine reports whether pattern matches anywhere in line.
+func matchLine(t *testing.T, line []byte, pattern string) bool {
+	t.Helper()
+	_, hasMatch := findMatch(t, line, pattern)
+	return hasMatch
+}
+
 func TestMatchLine(t *testing.T) {
 	// --- Existing test cases, now just checking for match/no-match ---
 	basicTestCases := []struct {
@@ -56,6 +91,42 @@ func TestMatchLine(t *testing.T) {
 			line: []byte("$#%"), pattern: `\w`,
 			expectedMatch: false,
 		},
+		// Whitespace '\s' and negated classes
+		{
+			name: `Whitespace (\s): Match tab`,
+			line: []byte("a\tb"), pattern: `a\sb`,
+			expectedMatch: true,
+		},
+		{
+			name: `Whitespace (\s): No match`,
+			line: []byte("a-b"), pattern: `a\sb`,
+			expectedMatch: false,
+		},
+		{
+			name: `Non-whitespace (\S): Match`,
+			line: []byte("  x "), pattern: `\S`,
+			expectedMatch: true,
+		},
+		{
+			name: `Non-digit (\D): No match`,
+			line: []byte("123"), pattern: `\D`,
+			expectedMatch: false,
+		},
+		{
+			name: `Non-alphanumeric (\W): Match`,
+			line: []byte("ab_c.d"), pattern: `\W`,
+			expectedMatch: true,
+		},
+		{
+			name: `Negated classes in group: Match`,
+			line: []byte("  7"), pattern: `^\s*[\S\d]$`,
+			expectedMatch: true,
+		},
+		{
+			name: `Negated classes in group: No match`,
+			line: []byte("abc"), pattern: `[^\W\d]\d`,
+			expectedMatch: false,
+		},
 		// Start Anchor '^'
 		{
 			name: "Start Anchor (^): Match at beginning",
@@ -100,6 +171,441 @@ func TestMatchLine(t *testing.T) {
 			line: []byte("xyz"), pattern: "[^xyz]",
 			expectedMatch: false,
 		},
+		// Character set ranges
+		{
+			name: "Range Group: Match found",
+			line: []byte("--q--"), pattern: "[a-z0-9]",
+			expectedMatch: true,
+		},
+		{
+			name: "Range Group: No match",
+			line: []byte("ABC"), pattern: "[a-z0-9]",
+			expectedMatch: false,
+		},
+		{
+			name: "Negative Range Group: Match found",
+			line: []byte("123a"), pattern: "[^0-9]",
+			expectedMatch: true,
+		},
+		{
+			name: "Escaped closing bracket in group",
+			line: []byte("a]b"), pattern: `[\]]`,
+			expectedMatch: true,
+		},
+		// POSIX classes
+		{
+			name: "POSIX class: alpha",
+			line: []byte("123x"), pattern: "[[:alpha:]]",
+			expectedMatch: true,
+		},
+		{
+			name: "POSIX class: space",
+			line: []byte("a\tb"), pattern: "a[[:space:]]b",
+			expectedMatch: true,
+		},
+		{
+			name: "POSIX class: punct",
+			line: []byte("abc 123"), pattern: "[[:punct:]]",
+			expectedMatch: false,
+		},
+		{
+			name: "POSIX class: negated xdigit",
+			line: []byte("c0ffee"), pattern: "[^[:xdigit:]]",
+			expectedMatch: false,
+		},
+		{
+			name: "POSIX class: upper and lower",
+			line: []byte("aB"), pattern: "[[:lower:]][[:upper:]]",
+			expectedMatch: true,
+		},
+		// Back-references '\1'..'\9'
+		{
+			name: "Back-reference: Repeated word",
+			line: []byte("it is is here"), pattern: `(\w+) \1`,
+			expectedMatch: true,
+		},
+		{
+			name: "Back-reference: No repetition",
+			line: []byte("cat dog"), pattern: `^(\w+) \1$`,
+			expectedMatch: false,
+		},
+		{
+			name: "Back-reference: Alternation inside the group",
+			line: []byte("dog and dog"), pattern: `(cat|dog) and \1`,
+			expectedMatch: true,
+		},
+		{
+			name: "Back-reference: Must equal the text captured, not the pattern",
+			line: []byte("cat and dog"), pattern: `(cat|dog) and \1`,
+			expectedMatch: false,
+		},
+		{
+			name: "Back-reference: Multiple groups",
+			line: []byte("abcba"), pattern: `^(a)(b)c\2\1$`,
+			expectedMatch: true,
+		},
+		// Atomic groups '(?>...)' and possessive quantifiers
+		{
+			name: "Greedy star gives back a character",
+			line: []byte("aaa"), pattern: `a*a`,
+			expectedMatch: true,
+		},
+		{
+			name: "Possessive star never gives back",
+			line: []byte("aaa"), pattern: `a*+a`,
+			expectedMatch: false,
+		},
+		{
+			name: "Possessive plus followed by another token",
+			line: []byte("aab"), pattern: `^a++b$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Possessive optional never gives back",
+			line: []byte("ab"), pattern: `^a?+ab`,
+			expectedMatch: false,
+		},
+		{
+			name: "Atomic group commits to first alternative",
+			line: []byte("abc"), pattern: `(?>a|ab)c`,
+			expectedMatch: false,
+		},
+		{
+			name: "Ordinary group retries second alternative",
+			line: []byte("abc"), pattern: `(?:a|ab)c`,
+			expectedMatch: true,
+		},
+		{
+			name: "Atomic group with back-reference",
+			line: []byte("aaaa"), pattern: `(?>(a+))\1`,
+			expectedMatch: false,
+		},
+		{
+			name: "Capturing group with back-reference",
+			line: []byte("aaaa"), pattern: `(a+)\1`,
+			expectedMatch: true,
+		},
+		// Word boundaries '\b' and '\B'
+		{
+			name: "Word boundary: Whole word",
+			line: []byte("the cat sat"), pattern: `\bcat\b`,
+			expectedMatch: true,
+		},
+		{
+			name: "Word boundary: Inside a word",
+			line: []byte("concatenate"), pattern: `\bcat\b`,
+			expectedMatch: false,
+		},
+		{
+			name: "Non-word boundary: Inside a word",
+			line: []byte("concatenate"), pattern: `\Bcat\B`,
+			expectedMatch: true,
+		},
+		{
+			name: "Word boundary: Line start and end",
+			line: []byte("cat"), pattern: `^\bcat\b$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Word boundary: Line starting with a non-word character",
+			line: []byte(" cat"), pattern: `^\b`,
+			expectedMatch: false,
+		},
+		{
+			name: "Non-word boundary: Empty line",
+			line: []byte(""), pattern: `^\B$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Word boundary: Between a multibyte character and a digit",
+			line: []byte("price: €50"), pattern: `.\b50`,
+			expectedMatch: true,
+		},
+		{
+			name: "Non-word boundary: Between two multibyte characters",
+			line: []byte("€€"), pattern: `^.\B.$`,
+			expectedMatch: true,
+		},
+		// Lookahead and lookbehind
+		{
+			name: "Negative lookahead: Followed by the excluded suffix",
+			line: []byte("password_hash=x"), pattern: `password(?!_hash)`,
+			expectedMatch: false,
+		},
+		{
+			name: "Negative lookahead: Not followed by the excluded suffix",
+			line: []byte("password=x"), pattern: `password(?!_hash)`,
+			expectedMatch: true,
+		},
+		{
+			name: "Positive lookahead: Does not consume input",
+			line: []byte("foobar"), pattern: `^foo(?=bar)bar$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Positive lookbehind: Preceded by prefix",
+			line: []byte("cost: $42"), pattern: `(?<=\$)\d+`,
+			expectedMatch: true,
+		},
+		{
+			name: "Positive lookbehind: Not preceded by prefix",
+			line: []byte("cost: 42"), pattern: `(?<=\$)\d+`,
+			expectedMatch: false,
+		},
+		{
+			name: "Negative lookbehind: Preceded by excluded prefix",
+			line: []byte("unhappy"), pattern: `(?<!un)happy`,
+			expectedMatch: false,
+		},
+		{
+			name: "Negative lookbehind: At start of line",
+			line: []byte("happy"), pattern: `(?<!un)happy`,
+			expectedMatch: true,
+		},
+		{
+			name: "Lookbehind: Alternatives of different lengths",
+			line: []byte("mr. smith"), pattern: `(?<=mrs?\. )smith`,
+			expectedMatch: true,
+		},
+		{
+			name: "Lookbehind: Counts runes rather than bytes",
+			line: []byte("€5"), pattern: `(?<=^.)5`,
+			expectedMatch: true,
+		},
+		{
+			name: "Lookahead: With back-reference",
+			line: []byte("abab"), pattern: `(ab)(?=\1)`,
+			expectedMatch: true,
+		},
+		// Inline flags
+		{
+			name: "Case-insensitive flag: Literal",
+			line: []byte("Hello WORLD"), pattern: `(?i)world`,
+			expectedMatch: true,
+		},
+		{
+			name: "Case-insensitive flag: Scoped",
+			line: []byte("HELLO WORLD"), pattern: `(?i:hello) world`,
+			expectedMatch: false,
+		},
+		{
+			name: "Case-insensitive flag: Character set",
+			line: []byte("ABC"), pattern: `(?i)^[a-c]+$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Case-insensitive flag: Negated character set",
+			line: []byte("A"), pattern: `(?i)[^a]`,
+			expectedMatch: false,
+		},
+		{
+			name: "Case-insensitive flag: Non-ASCII letters",
+			line: []byte("ÉCOLE"), pattern: `(?i)^.cole$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Case-insensitive flag: Back-reference",
+			line: []byte("Abc aBC"), pattern: `(?i)(\w+) \1`,
+			expectedMatch: true,
+		},
+		{
+			name: "Case-sensitive back-reference",
+			line: []byte("Abc aBC"), pattern: `(\w+) \1`,
+			expectedMatch: false,
+		},
+		{
+			name: "Multiline flag: Anchors at embedded newline",
+			line: []byte("first\nsecond"), pattern: `(?m)^second$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Without multiline flag: Anchors only at the ends",
+			line: []byte("first\nsecond"), pattern: `^second`,
+			expectedMatch: false,
+		},
+		{
+			name: "Absolute start anchor ignores multiline flag",
+			line: []byte("first\nsecond"), pattern: `(?m)\Asecond`,
+			expectedMatch: false,
+		},
+		{
+			name: "Absolute end anchor ignores multiline flag",
+			line: []byte("first\nsecond"), pattern: `(?m)first\z`,
+			expectedMatch: false,
+		},
+		{
+			name: "End anchor before final newline",
+			line: []byte("last\n"), pattern: `last\Z`,
+			expectedMatch: true,
+		},
+		{
+			name: "Dot does not match newline by default",
+			line: []byte("a\nb"), pattern: `a.b`,
+			expectedMatch: false,
+		},
+		{
+			name: "Dot-all flag: Dot matches newline",
+			line: []byte("a\nb"), pattern: `(?s)a.b`,
+			expectedMatch: true,
+		},
+		{
+			name: "Verbose flag: Whitespace and comments ignored",
+			line: []byte("2024-01-31"), pattern: "(?x) ^ \\d{4} - \\d{2}  # year and month\n - \\d{2} $",
+			expectedMatch: true,
+		},
+		{
+			name: "Verbose flag: Escaped space is literal",
+			line: []byte("a b"), pattern: `(?x)a\ b`,
+			expectedMatch: true,
+		},
+		{
+			name: "Verbose flag: Scoped",
+			line: []byte("ab c"), pattern: `(?x: a b ) c`,
+			expectedMatch: true,
+		},
+		// Unicode properties
+		{
+			name: "Unicode property: Letter category",
+			line: []byte("日本語"), pattern: `^\p{L}+$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode property: Uppercase letter",
+			line: []byte("élan Ébène"), pattern: `\p{Lu}\p{Ll}`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode property: Script",
+			line: []byte("alpha: α"), pattern: `\p{Greek}`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode property: Negated",
+			line: []byte("abc"), pattern: `\P{L}`,
+			expectedMatch: false,
+		},
+		{
+			name: "Unicode property: One-letter name",
+			line: []byte("x٣"), pattern: `x\pN`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode property: In character set",
+			line: []byte("Ωmega"), pattern: `^[\p{Greek}\d]`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode property: Negated in negated set",
+			line: []byte("β"), pattern: `[^\P{Greek}]`,
+			expectedMatch: true,
+		},
+		{
+			name: "Digit class is ASCII by default",
+			line: []byte("٣"), pattern: `\d`,
+			expectedMatch: false,
+		},
+		{
+			name: "Unicode flag: Digit class",
+			line: []byte("٣"), pattern: `(?u)\d`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode flag: Word class",
+			line: []byte("naïve"), pattern: `(?u)^\w+$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode flag: Whitespace class",
+			line: []byte("a\u00a0b"), pattern: `(?u)a\sb`,
+			expectedMatch: true,
+		},
+		{
+			name: "Unicode flag: Word boundary",
+			line: []byte("naïve"), pattern: `(?u)ï\b`,
+			expectedMatch: false,
+		},
+		{
+			name: "Word boundary is ASCII by default",
+			line: []byte("naïve"), pattern: `a\b`,
+			expectedMatch: true,
+		},
+		// Escape sequences
+		{
+			name: "Escape: Tab",
+			line: []byte("a\tb"), pattern: `a\tb`,
+			expectedMatch: true,
+		},
+		{
+			name: "Escape: Letter t is not a tab",
+			line: []byte("atb"), pattern: `a\tb`,
+			expectedMatch: false,
+		},
+		{
+			name: "Escape: Hexadecimal",
+			line: []byte("A-B"), pattern: `\x41\x2d\x{42}`,
+			expectedMatch: true,
+		},
+		{
+			name: "Escape: Unicode code point",
+			line: []byte("café"), pattern: `caf\u00e9`,
+			expectedMatch: true,
+		},
+		{
+			name: "Escape: Control character in set",
+			line: []byte("a\x01b"), pattern: `a[\x00-\x1f]b`,
+			expectedMatch: true,
+		},
+		{
+			name: "Escape: Quoted metacharacters",
+			line: []byte("1+1=2 (true)"), pattern: `\Q1+1=2 (true)\E$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Escape: Quoted metacharacters are not operators",
+			line: []byte("11=2"), pattern: `\Q1+1=2`,
+			expectedMatch: false,
+		},
+		// Multibyte patterns
+		{
+			name: "Multibyte: Literal",
+			line: []byte("un café noir"), pattern: `café`,
+			expectedMatch: true,
+		},
+		{
+			name: "Multibyte: Quantified literal",
+			line: []byte("ééé"), pattern: `^é{3}$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Multibyte: Character set",
+			line: []byte("Grüße"), pattern: `Gr[äöü]ße`,
+			expectedMatch: true,
+		},
+		{
+			name: "Multibyte: Negated character set",
+			line: []byte("ü"), pattern: `^[^äöü]$`,
+			expectedMatch: false,
+		},
+		{
+			name: "Multibyte: Negated character set never matches inside a character",
+			line: []byte("é"), pattern: `[^é]`,
+			expectedMatch: false,
+		},
+		{
+			name: "Multibyte: Back-reference never matches inside a character",
+			line: []byte("é"), pattern: `([^é])\1?`,
+			expectedMatch: false,
+		},
+		{
+			name: "Multibyte: Range",
+			line: []byte("λόγος"), pattern: `^[α-ω]`,
+			expectedMatch: true,
+		},
+		{
+			name: "Multibyte: Word boundary after non-word character",
+			line: []byte("€50"), pattern: `€\b50`,
+			expectedMatch: true,
+		},
 		// Combination of patterns
 		{
 			name: "Combination: Match a literal and a digit",
@@ -128,20 +634,77 @@ func TestMatchLine(t *testing.T) {
 			expectedMatch: false,
 		},
 		{
-			name: "Match one or more times: codecrafters #02",
-			line: []byte("caaats"), pattern: `ca+at`,
+			name: "Match one or more times: codecrafters #02",
+			line: []byte("caaats"), pattern: `ca+at`,
+			expectedMatch: true,
+		},
+		// Bounded repetition '{n,m}'
+		{
+			name: "Bounded repetition: Exact count",
+			line: []byte("call 555-1234 now"), pattern: `\d{3}-\d{4}`,
+			expectedMatch: true,
+		},
+		{
+			name: "Bounded repetition: Too few repetitions",
+			line: []byte("call 55-1234 now"), pattern: `\d{3}-\d{4}`,
+			expectedMatch: false,
+		},
+		{
+			name: "Bounded repetition: Upper bound",
+			line: []byte("xaaay"), pattern: `xa{1,2}y`,
+			expectedMatch: false,
+		},
+		{
+			name: "Bounded repetition: Open upper bound",
+			line: []byte("xaaay"), pattern: `xa{2,}y`,
+			expectedMatch: true,
+		},
+		{
+			name: "Bounded repetition: Zero repetitions",
+			line: []byte("xy"), pattern: `^xa{0}y$`,
+			expectedMatch: true,
+		},
+		// Empty alternatives and groups
+		{
+			name: "Empty: Empty pattern matches any line",
+			line: []byte("abc"), pattern: ``,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Empty pattern matches an empty line",
+			line: []byte(""), pattern: ``,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Trailing empty alternative matches anything",
+			line: []byte("xyz"), pattern: `colou?r|`,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Empty branch in a group",
+			line: []byte("xz"), pattern: `^x(|y)z$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Non-empty branch in a group",
+			line: []byte("xyz"), pattern: `^x(y|)z$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Empty group",
+			line: []byte("ab"), pattern: `^a()b$`,
+			expectedMatch: true,
+		},
+		{
+			name: "Empty: Repeated empty group",
+			line: []byte("ab"), pattern: `^a()*b$`,
 			expectedMatch: true,
 		},
 	}
 
 	for _, tc := range basicTestCases {
 		t.Run(tc.name, func(t *testing.T) {
-			// Updated call to Simulate, ignoring the captures slice
-			actualMatch, err := nfasimulator.Simulate(tc.line, tc.pattern)
-			if err != nil {
-				t.Errorf("error '%s':", err)
-			}
-
+			actualMatch := matchLine(t, tc.line, tc.pattern)
 			if actualMatch != tc.expectedMatch {
 				t.Errorf("Pattern '%s' on line '%s': expected match %v, but got %v",
 					tc.pattern, string(tc.line), tc.expectedMatch, actualMatch)
@@ -215,14 +778,83 @@ func TestMatchLine(t *testing.T) {
 				{Start: 4, End: 6}, // "ab"
 			},
 		},
+		{
+			name:          "Captures: Group with bounded repetition",
+			line:          []byte("xabcd"),
+			pattern:       "x(\\w){2,3}",
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 0, End: 4}, // "xabc"
+				{Start: 3, End: 4}, // "c"
+			},
+		},
+		{
+			name:          "Captures: Back-reference",
+			line:          []byte("x abab y"),
+			pattern:       `(ab)\1`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 2, End: 6}, // "abab"
+				{Start: 2, End: 4}, // "ab"
+			},
+		},
+		{
+			name:          "Captures: Non-capturing group",
+			line:          []byte("catdog"),
+			pattern:       `(?:cat|dog)(dog)`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 0, End: 6}, // "catdog"
+				{Start: 3, End: 6}, // "dog"
+			},
+		},
+		{
+			name:          "Captures: Back-reference numbering skips non-capturing groups",
+			line:          []byte("ab-b"),
+			pattern:       `(?:a)(b)-\1`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 0, End: 4}, // "ab-b"
+				{Start: 1, End: 2}, // "b"
+			},
+		},
+		{
+			name:          "Captures: Lazy star inside a group",
+			line:          []byte("key=a=b"),
+			pattern:       `(\w+?)=(.*)`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 0, End: 7}, // "key=a=b"
+				{Start: 0, End: 3}, // "key"
+				{Start: 4, End: 7}, // "a=b"
+			},
+		},
+		{
+			name:          "Captures: Set inside an atomic group",
+			line:          []byte("xaab"),
+			pattern:       `(?>(a+))b`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 1, End: 4}, // "aab"
+				{Start: 1, End: 3}, // "aa"
+			},
+		},
+		{
+			name:          "Captures: Set inside a lookahead",
+			line:          []byte("key=value"),
+			pattern:       `^(?=(\w+)=)`,
+			expectedMatch: true,
+			expectedCaptures: []nfasimulator.Capture{
+				{Start: 0, End: 0}, // ""
+				{Start: 0, End: 3}, // "key"
+			},
+		},
 	}
 
 	for _, tc := range captureTestCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualMatch, actualCaptures, err := nfasimulator.Simulate(tc.line, tc.pattern)
-			if err != nil {
-				t.Errorf("error '%s':", err)
-			}
+			result, actualMatch := findMatch(t, tc.line, tc.pattern)
+			actualCaptures := result.Captures
 
 			if actualMatch != tc.expectedMatch {
 				t.Errorf("Pattern '%s' on line '%s': expected match %v, but got %v",
@@ -238,6 +870,521 @@ func TestMatchLine(t *testing.T) {
 	}
 }
 
+func TestProcessLinesWithGroup(t *testing.T) {
+	input := "10:00 ERROR disk full\n10:01 INFO ok\n10:02 WARN slow\n"
+	pattern := `^(?P<time>\d+:\d+) (?P<level>ERROR|WARN)`
+
+	testCases := []struct {
+		name     string
+		group    string
+		expected [][]byte
+	}{
+		{
+			name:     "Whole line",
+			group:    "",
+			expected: [][]byte{[]byte("10:00 ERROR disk full"), []byte("10:02 WARN slow")},
+		},
+		{
+			name:     "Group by name",
+			group:    "level",
+			expected: [][]byte{[]byte("ERROR"), []byte("WARN")},
+		},
+		{
+			name:     "Group by number",
+			group:    "1",
+			expected: [][]byte{[]byte("10:00"), []byte("10:02")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hasMatch, lines, err := processLines(strings.NewReader(input), mustCompile(t, []string{pattern}, compileOptions{}), outputOptions{group: tc.group})
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !hasMatch {
+				t.Fatalf("processLines expected a match")
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("  got: %q", lines)
+				t.Errorf(" want: %q", tc.expected)
+			}
+		})
+	}
+
+	_, _, err := processLines(strings.NewReader(input), mustCompile(t, []string{pattern}, compileOptions{}), outputOptions{group: "missing"})
+	if err == nil || err.Error() != "unknown capture group missing" {
+		t.Errorf("expected unknown group error, got %v", err)
+	}
+}
+
+func TestCheckGroup(t *testing.T) {
+	testCases := []struct {
+		name     string
+		patterns []string
+		compile  compileOptions
+		group    string
+		valid    bool
+	}{
+		{name: "Whole match", patterns: []string{`a`}, group: "0", valid: true},
+		{name: "Existing group", patterns: []string{`(a)(b)`}, group: "2", valid: true},
+		{name: "Missing group", patterns: []string{`(a)`}, group: "2", valid: false},
+		{name: "Negative group", patterns: []string{`(a)`}, group: "-1", valid: false},
+		{name: "Existing name", patterns: []string{`(?P<x>a)`}, group: "x", valid: true},
+		{name: "Missing name", patterns: []string{`(?P<x>a)`}, group: "y", valid: false},
+		{name: "Groups of several patterns", patterns: []string{`(a)`, `(b)`}, group: "2", valid: true},
+		{name: "Pattern markers are not groups", patterns: []string{`(a)`, `(b)`}, group: "3", valid: false},
+		{name: "Fixed strings have only the whole match", patterns: []string{"(a)"}, compile: compileOptions{fixed: true}, group: "1", valid: false},
+		{name: "No patterns", patterns: nil, group: "0", valid: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mustCompile(t, tc.patterns, tc.compile).checkGroup(tc.group)
+			if tc.valid && err != nil {
+				t.Errorf("checkGroup(%q) returned an unexpected error: %v", tc.group, err)
+			}
+			if !tc.valid && (err == nil || err.Error() != "unknown capture group "+tc.group) {
+				t.Errorf("checkGroup(%q) = %v, want an unknown capture group error", tc.group, err)
+			}
+		})
+	}
+}
+
+func TestProcessLinesOnlyMatching(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		pattern  string
+		group    string
+		expected [][]byte
+	}{
+		{
+			name:     "Greedy star takes the longest match",
+			input:    "<a><b>",
+			pattern:  `<.*>`,
+			expected: [][]byte{[]byte("<a><b>")},
+		},
+		{
+			name:     "Lazy star takes the shortest match",
+			input:    "<a><b>",
+			pattern:  `<.*?>`,
+			expected: [][]byte{[]byte("<a>"), []byte("<b>")},
+		},
+		{
+			name:     "Lazy plus takes one repetition",
+			input:    "aaaa",
+			pattern:  `a+?`,
+			expected: [][]byte{[]byte("a"), []byte("a"), []byte("a"), []byte("a")},
+		},
+		{
+			name:     "Lazy optional prefers to skip",
+			input:    "ab",
+			pattern:  `(a??)b`,
+			group:    "1",
+			expected: [][]byte{[]byte("a")},
+		},
+		{
+			name:     "Lazy bounded repetition takes the minimum",
+			input:    "aaaaa",
+			pattern:  `a{2,3}?`,
+			expected: [][]byte{[]byte("aa"), []byte("aa")},
+		},
+		{
+			name:     "Negated set skips whole multibyte characters",
+			input:    "€a€",
+			pattern:  `[^€]`,
+			expected: [][]byte{[]byte("a")},
+		},
+		{
+			name:     "Empty matches are not printed",
+			input:    "abc",
+			pattern:  `x*`,
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := outputOptions{group: tc.group, onlyMatching: true}
+			_, lines, err := processLines(strings.NewReader(tc.input), mustCompile(t, []string{tc.pattern}, compileOptions{}), options)
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("  got: %q", lines)
+				t.Errorf(" want: %q", tc.expected)
+			}
+		})
+	}
+}
+
+func TestProcessLinesVerbose(t *testing.T) {
+	input := "key = value\nkey=value\n"
+	pattern := `^ (\w+) \s* = \s* (\w+) $  # key = value`
+
+	_, lines, err := processLines(strings.NewReader(input), mustCompile(t, []string{pattern}, compileOptions{flags: token.FlagExtended}), outputOptions{group: "2"})
+	if err != nil {
+		t.Fatalf("processLines returned an unexpected error: %v", err)
+	}
+	expected := [][]byte{[]byte("value"), []byte("value")}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("  got: %q", lines)
+		t.Errorf(" want: %q", expected)
+	}
+}
+
+func TestProcessLinesBasic(t *testing.T) {
+	input := "a+b\naab\nabab\n(x)\n"
+	testCases := []struct {
+		pattern  string
+		expected [][]byte
+	}{
+		{pattern: `a+b`, expected: [][]byte{[]byte("a+b")}},
+		{pattern: `^\(ab\)\{2\}$`, expected: [][]byte{[]byte("abab")}},
+		{pattern: `(x)\|+`, expected: [][]byte{[]byte("a+b"), []byte("(x)")}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pattern, func(t *testing.T) {
+			_, lines, err := processLines(strings.NewReader(input), mustCompile(t, []string{tc.pattern}, compileOptions{basic: true}), outputOptions{})
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("  got: %q", lines)
+				t.Errorf(" want: %q", tc.expected)
+			}
+		})
+	}
+}
+
+func TestProcessLinesFixed(t *testing.T) {
+	input := "key AKIA123 here\nnothing\nsk_live_9 and AKIA123\na.b\n"
+	testCases := []struct {
+		name     string
+		pattern  string
+		options  outputOptions
+		expected [][]byte
+	}{
+		{
+			name:     "any of the strings",
+			pattern:  "AKIA123\nsk_live_9",
+			expected: [][]byte{[]byte("key AKIA123 here"), []byte("sk_live_9 and AKIA123")},
+		},
+		{
+			name:     "regex operators are literals",
+			pattern:  "a.b\n(",
+			expected: [][]byte{[]byte("a.b")},
+		},
+		{
+			name:     "only matching",
+			pattern:  "AKIA123\nsk_live_9",
+			options:  outputOptions{onlyMatching: true},
+			expected: [][]byte{[]byte("AKIA123"), []byte("sk_live_9"), []byte("AKIA123")},
+		},
+		{
+			name:     "empty string matches every line",
+			pattern:  "",
+			expected: [][]byte{[]byte("key AKIA123 here"), []byte("nothing"), []byte("sk_live_9 and AKIA123"), []byte("a.b")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, lines, err := processLines(strings.NewReader(input), mustCompile(t, []string{tc.pattern}, compileOptions{fixed: true}), tc.options)
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("  got: %q", lines)
+				t.Errorf(" want: %q", tc.expected)
+			}
+		})
+	}
+}
+
+func TestFixedMatchesReportPattern(t *testing.T) {
+	compiled := mustCompile(t, []string{"cat", "dog"}, compileOptions{fixed: true})
+	results, err := compiled.matches([]byte("hotdog"))
+	if err != nil {
+		t.Fatalf("matches returned an unexpected error: %v", err)
+	}
+	var actual []nfasimulator.MatchResult
+	for result := range results {
+		actual = append(actual, result)
+	}
+	expected := []nfasimulator.MatchResult{
+		{Captures: []nfasimulator.Capture{{Start: 3, End: 6}}, Pattern: 1},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("  got: %+v", actual)
+		t.Errorf(" want: %+v", expected)
+	}
+}
+
+func TestProcessLinesPatterns(t *testing.T) {
+	input := "error: disk\nwarn: cpu\ninfo: ok\nabab\n"
+	testCases := []struct {
+		name     string
+		patterns []string
+		compile  compileOptions
+		options  outputOptions
+		expected [][]byte
+	}{
+		{
+			name:     "any of the patterns",
+			patterns: []string{"error", "warn"},
+			expected: [][]byte{[]byte("error: disk"), []byte("warn: cpu")},
+		},
+		{
+			name:     "pattern numbers",
+			patterns: []string{"error", "warn", "ok"},
+			options:  outputOptions{patternNumber: true},
+			expected: [][]byte{[]byte("1:error: disk"), []byte("2:warn: cpu"), []byte("3:info: ok")},
+		},
+		{
+			name:     "leftmost match decides the pattern number",
+			patterns: []string{"cpu", "warn"},
+			options:  outputOptions{patternNumber: true},
+			expected: [][]byte{[]byte("2:warn: cpu")},
+		},
+		{
+			name:     "pattern numbers of each match",
+			patterns: []string{"o", "(?i)ERROR"},
+			options:  outputOptions{patternNumber: true, onlyMatching: true},
+			expected: [][]byte{[]byte("2:error"), []byte("1:o"), []byte("1:o")},
+		},
+		{
+			name:     "back-references refer to the pattern's own groups",
+			patterns: []string{"(x)", `(ab)\1`},
+			expected: [][]byte{[]byte("abab")},
+		},
+		{
+			name:     "flags do not carry over to the next pattern",
+			patterns: []string{"(?i)x", "INFO"},
+			expected: nil,
+		},
+		{
+			name:     "groups are numbered across patterns",
+			patterns: []string{"(e)(r)", "(w)(a)"},
+			options:  outputOptions{group: "4"},
+			expected: [][]byte{[]byte("a")},
+		},
+		{
+			name:     "empty pattern matches every line",
+			patterns: []string{"x", ""},
+			options:  outputOptions{patternNumber: true},
+			expected: [][]byte{[]byte("2:error: disk"), []byte("2:warn: cpu"), []byte("2:info: ok"), []byte("2:abab")},
+		},
+		{
+			name:     "no patterns match nothing",
+			patterns: nil,
+			expected: nil,
+		},
+		{
+			name:     "fixed strings",
+			patterns: []string{"cpu", "zzz\ndisk"},
+			compile:  compileOptions{fixed: true},
+			options:  outputOptions{patternNumber: true},
+			expected: [][]byte{[]byte("2:error: disk"), []byte("1:warn: cpu")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, lines, err := processLines(strings.NewReader(input), mustCompile(t, tc.patterns, tc.compile), tc.options)
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(lines, tc.expected) {
+				t.Errorf("  got: %q", lines)
+				t.Errorf(" want: %q", tc.expected)
+			}
+		})
+	}
+}
+
+func TestFixedAndRegexReportTheSamePattern(t *testing.T) {
+	input := "xabc\nab\nabcabc\n"
+	testCases := []struct {
+		name     string
+		patterns []string
+		options  outputOptions
+	}{
+		{name: "Shorter pattern first", patterns: []string{"ab", "abc"}, options: outputOptions{patternNumber: true}},
+		{name: "Longer pattern first", patterns: []string{"abc", "ab"}, options: outputOptions{patternNumber: true}},
+		{name: "Only matching", patterns: []string{"ab", "abc", "c"}, options: outputOptions{patternNumber: true, onlyMatching: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, regexLines, err := processLines(strings.NewReader(input), mustCompile(t, tc.patterns, compileOptions{}), tc.options)
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			_, fixedLines, err := processLines(strings.NewReader(input), mustCompile(t, tc.patterns, compileOptions{fixed: true}), tc.options)
+			if err != nil {
+				t.Fatalf("processLines returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(fixedLines, regexLines) {
+				t.Errorf(" -F: %q", fixedLines)
+				t.Errorf(" -E: %q", regexLines)
+			}
+		})
+	}
+}
+
+func TestFormatErrorNamesPattern(t *testing.T) {
+	patterns := []string{"a", "b("}
+	_, err := compilePatterns(patterns, compileOptions{})
+	expected := "error: pattern 2: unmatched group opener\n  b(\n   ^"
+	if actual := formatError(err, patterns); actual != expected {
+		t.Errorf("  got:\n%s", actual)
+		t.Errorf(" want:\n%s", expected)
+	}
+}
+
+func TestPatternFileFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	if err := os.WriteFile(path, []byte("warn\n\nerror\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	patterns := []string{"first"}
+	given := false
+	if err := (patternFileFlag{&patterns, &given}).Set(path); err != nil {
+		t.Fatalf("Set returned an unexpected error: %v", err)
+	}
+	expected := []string{"first", "warn", "", "error"}
+	if !given || !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("  got: %q", patterns)
+		t.Errorf(" want: %q", expected)
+	}
+}
+
+func TestDialectFlagLastWins(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected dialect
+	}{
+		{args: nil, expected: dialectExtended},
+		{args: []string{"-G"}, expected: dialectBasic},
+		{args: []string{"-E", "-G"}, expected: dialectBasic},
+		{args: []string{"-G", "-E"}, expected: dialectExtended},
+		{args: []string{"-G", "-F"}, expected: dialectFixed},
+		{args: []string{"-F", "-G=false"}, expected: dialectFixed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			selected := dialectExtended
+			flags := flag.NewFlagSet("mygrep", flag.ContinueOnError)
+			flags.Var(dialectFlag{&selected, dialectExtended}, "E", "")
+			flags.Var(dialectFlag{&selected, dialectBasic}, "G", "")
+			flags.Var(dialectFlag{&selected, dialectFixed}, "F", "")
+			if err := flags.Parse(tc.args); err != nil {
+				t.Fatalf("Parse returned an unexpected error: %v", err)
+			}
+			if selected != tc.expected {
+				t.Errorf("selected dialect %d, want %d", selected, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCompilePatternsFailsBeforeInput(t *testing.T) {
+	// An invalid pattern is reported by compilePatterns itself, before
+	// any line has been read.
+	if _, err := compilePatterns([]string{`a(`}, compileOptions{}); err == nil {
+		t.Fatal("compilePatterns returned no error for an invalid pattern")
+	}
+}
+
+func TestProcessLinesLeavesNoGoroutines(t *testing.T) {
+	compiled := mustCompile(t, []string{`a`, `(b)\1`}, compileOptions{})
+	input := strings.Repeat("aaaa bb\n", 200)
+
+	before := runtime.NumGoroutine()
+	_, lines, err := processLines(strings.NewReader(input), compiled, outputOptions{})
+	if err != nil {
+		t.Fatalf("processLines returned an unexpected error: %v", err)
+	}
+	if len(lines) != 200 {
+		t.Fatalf("got %d lines, want 200", len(lines))
+	}
+	// Only the first match of each line is used; the search for the
+	// others must not be left running.
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("processLines left %d goroutines running", after-before)
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pattern  string
+		expected string
+	}{
+		{
+			name:    "Single error",
+			pattern: `ab(cd`,
+			expected: "error: unmatched group opener\n" +
+				"  ab(cd\n" +
+				"    ^",
+		},
+		{
+			name:    "Every error is shown",
+			pattern: `é\y[z-a]`,
+			expected: "error: unknown escape sequence \\y\n" +
+				"  é\\y[z-a]\n" +
+				"   ^~\n" +
+				"error: invalid character set range z-a: range values reversed\n" +
+				"  é\\y[z-a]\n" +
+				"      ^~~",
+		},
+		{
+			name:    "Only the line of a multi-line pattern that holds the error",
+			pattern: "(?x)a\n\tb{2,1}",
+			expected: "error: invalid repetition range {2,1}\n" +
+				"  \tb{2,1}\n" +
+				"  \t ^~~~~",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compilePatterns([]string{tc.pattern}, compileOptions{})
+			if err == nil {
+				t.Fatalf("expected an error for pattern %q", tc.pattern)
+			}
+			if actual := formatError(err, []string{tc.pattern}); actual != tc.expected {
+				t.Errorf("  got:\n%s", actual)
+				t.Errorf(" want:\n%s", tc.expected)
+			}
+		})
+	}
+}
+
+func TestUnboundedLookbehind(t *testing.T) {
+	_, err := compilePatterns([]string{`(?<=a+)b`}, compileOptions{})
+	if err == nil || err.Error() != "lookbehind requires a pattern of bounded length" {
+		t.Errorf("expected unbounded lookbehind error, got %v", err)
+	}
+}
+
+func TestLongLineWithoutMatch(t *testing.T) {
+	// Each of these took time quadratic in the length of the line when
+	// every start position was searched from scratch.
+	line := []byte(strings.Repeat("a", 50000))
+	for _, pattern := range []string{`x?a*b`, `(a*)*b`, `(?:a|aa)*c`, `(x)?a*\1?b`} {
+		t.Run(pattern, func(t *testing.T) {
+			if matchLine(t, line, pattern) {
+				t.Errorf("pattern %q matched a line of a's", pattern)
+			}
+		})
+	}
+}
+
 func TestSimulateWithFile(t *testing.T) {
 	testCases := []struct {
 		name          string
@@ -306,12 +1453,7 @@ func TestSimulateWithFile(t *testing.T) {
 			}
 
 			// NOTE: This tests the pattern against the entire file content at once.
-			// Updated call to Simulate, ignoring the captures slice
-			captures, err := nfasimulator.Simulate(fileBytes, tc.pattern)
-			if err != nil {
-				t.Errorf("Simulate returned an unexpected error: %v", err)
-			}
-			_, actualMatch := <- captures
+			actualMatch := matchLine(t, fileBytes, tc.pattern)
 			if actualMatch != tc.expectedMatch {
 				t.Errorf("Pattern '%s' on file with content '%s': expected match %v, but got %v",
 					tc.pattern, tc.fileContent, tc.expectedMatch, actualMatch)
@@ -0,0 +1,148 @@
+// Package ahocorasick finds occurrences of many literal strings in a single
+// pass over a text, using the Aho-Corasick automaton: a trie of the
+// patterns in which every node also links to the node for the longest
+// proper suffix of its string that is still in the trie.
+package ahocorasick
+
+// Match is an occurrence of the pattern with index Pattern at
+// text[Start:End].
+type Match struct {
+	Pattern int
+	Start   int
+	End     int
+}
+
+type node struct {
+	next map[byte]int
+	// fail is the node for the longest proper suffix of this node's
+	// string that is also in the trie.
+	fail int
+	// pattern is the index of the pattern spelled by this node, or -1.
+	pattern int
+	// output is the nearest node along the fail links, this one
+	// excluded, that spells a pattern, or -1.
+	output int
+	depth  int
+}
+
+func newNode(depth int) node {
+	return node{next: make(map[byte]int), pattern: -1, output: -1, depth: depth}
+}
+
+// Automaton searches for a fixed set of patterns. It is not modified by
+// searching, so it can be shared between goroutines.
+type Automaton struct {
+	nodes []node
+	// empty is the index of the empty pattern, or -1 when there is none.
+	empty int
+}
+
+// New builds the automaton for patterns. When a pattern occurs more than
+// once, matches report the index of its first occurrence.
+func New(patterns []string) *Automaton {
+	a := &Automaton{nodes: []node{newNode(0)}, empty: -1}
+
+	for i, pattern := range patterns {
+		if pattern == "" {
+			if a.empty == -1 {
+				a.empty = i
+			}
+			continue
+		}
+		current := 0
+		for j := 0; j < len(pattern); j++ {
+			next, ok := a.nodes[current].next[pattern[j]]
+			if !ok {
+				next = len(a.nodes)
+				a.nodes = append(a.nodes, newNode(j+1))
+				a.nodes[current].next[pattern[j]] = next
+			}
+			current = next
+		}
+		if a.nodes[current].pattern == -1 {
+			a.nodes[current].pattern = i
+		}
+	}
+
+	// Links are set breadth first, so that those of every shorter suffix
+	// are known by the time they are needed.
+	var queue []int
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for character, child := range a.nodes[current].next {
+			fail := a.transition(a.nodes[current].fail, character)
+			a.nodes[child].fail = fail
+			if a.nodes[fail].pattern != -1 {
+				a.nodes[child].output = fail
+			} else {
+				a.nodes[child].output = a.nodes[fail].output
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	return a
+}
+
+// transition returns the node reached from state on character, following
+// fail links until one has an edge for it.
+func (a *Automaton) transition(state int, character byte) int {
+	for {
+		if next, ok := a.nodes[state].next[character]; ok {
+			return next
+		}
+		if state == 0 {
+			return 0
+		}
+		state = a.nodes[state].fail
+	}
+}
+
+// FindAll returns the successive non-overlapping matches in text. The
+// leftmost match is taken first and, among those starting at the same
+// position, the one of the pattern with the lowest index, as a regex
+// alternation of the patterns in order would choose. The search resumes
+// where a match ends, or one byte further after an empty match. The empty
+// pattern, if present, matches at every position.
+func (a *Automaton) FindAll(text []byte) []Match {
+	type candidate struct{ pattern, end int }
+	first := make([]candidate, len(text)+1)
+	for i := range first {
+		first[i] = candidate{pattern: a.empty}
+	}
+
+	state := 0
+	for i := 0; i < len(text); i++ {
+		state = a.transition(state, text[i])
+		end := i + 1
+		for current := state; current > 0; current = a.nodes[current].output {
+			n := a.nodes[current]
+			if n.pattern == -1 {
+				continue
+			}
+			start := end - n.depth
+			if c := first[start]; c.pattern == -1 || n.pattern < c.pattern {
+				first[start] = candidate{pattern: n.pattern, end: end}
+			}
+		}
+	}
+
+	var matches []Match
+	for i := 0; i <= len(text); {
+		c := first[i]
+		if c.pattern == -1 {
+			i++
+			continue
+		}
+		if c.pattern == a.empty {
+			c.end = i
+		}
+		matches = append(matches, Match{Pattern: c.pattern, Start: i, End: c.end})
+		i = max(c.end, i+1)
+	}
+	return matches
+}
@@ -0,0 +1,104 @@
+package ahocorasick
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		expected []Match
+	}{
+		{
+			name:     "single pattern",
+			patterns: []string{"ab"},
+			text:     "xabyab",
+			expected: []Match{{Pattern: 0, Start: 1, End: 3}, {Pattern: 0, Start: 4, End: 6}},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"abc"},
+			text:     "ababab",
+			expected: nil,
+		},
+		{
+			name:     "reports which pattern matched",
+			patterns: []string{"he", "she", "hers"},
+			text:     "ushers",
+			expected: []Match{{Pattern: 1, Start: 1, End: 4}},
+		},
+		{
+			name:     "earliest pattern among the matches at the leftmost position",
+			patterns: []string{"ab", "abc", "a"},
+			text:     "abcd",
+			expected: []Match{{Pattern: 0, Start: 0, End: 2}},
+		},
+		{
+			name:     "earliest pattern wins even when it is the longest",
+			patterns: []string{"abc", "a", "ab"},
+			text:     "abcd",
+			expected: []Match{{Pattern: 0, Start: 0, End: 3}},
+		},
+		{
+			name:     "earliest pattern is found through a fail link",
+			patterns: []string{"bc", "xbcd"},
+			text:     "xbcd",
+			expected: []Match{{Pattern: 1, Start: 0, End: 4}},
+		},
+		{
+			name:     "leftmost match wins over a longer overlapping one",
+			patterns: []string{"bcd", "ab"},
+			text:     "abcd",
+			expected: []Match{{Pattern: 1, Start: 0, End: 2}},
+		},
+		{
+			name:     "pattern found through a fail link",
+			patterns: []string{"abcd", "bc"},
+			text:     "abce",
+			expected: []Match{{Pattern: 1, Start: 1, End: 3}},
+		},
+		{
+			name:     "duplicate pattern reports its first index",
+			patterns: []string{"x", "ab", "ab"},
+			text:     "ab",
+			expected: []Match{{Pattern: 1, Start: 0, End: 2}},
+		},
+		{
+			name:     "empty pattern matches between other matches",
+			patterns: []string{"b", ""},
+			text:     "ab",
+			expected: []Match{{Pattern: 1, Start: 0, End: 0}, {Pattern: 0, Start: 1, End: 2}, {Pattern: 1, Start: 2, End: 2}},
+		},
+		{
+			name:     "empty pattern given first wins everywhere",
+			patterns: []string{"", "b"},
+			text:     "ab",
+			expected: []Match{{Pattern: 0, Start: 0, End: 0}, {Pattern: 0, Start: 1, End: 1}, {Pattern: 0, Start: 2, End: 2}},
+		},
+		{
+			name:     "empty pattern matches empty text",
+			patterns: []string{""},
+			text:     "",
+			expected: []Match{{Pattern: 0, Start: 0, End: 0}},
+		},
+		{
+			name:     "multibyte text",
+			patterns: []string{"é"},
+			text:     "café",
+			expected: []Match{{Pattern: 0, Start: 3, End: 5}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := New(tt.patterns).FindAll([]byte(tt.text))
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("got:  %+v", actual)
+				t.Errorf("want: %+v", tt.expected)
+			}
+		})
+	}
+}
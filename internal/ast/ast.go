@@ -10,10 +10,21 @@ type baseASTNode struct{}
 
 func (n *baseASTNode) isASTNode() {}
 
+// CaptureGroupNode records the text matched by Child under GroupIndex.
+// Name is empty unless the group was written as (?P<name>...).
 type CaptureGroupNode struct {
 	baseASTNode
 	Child      ASTNode
 	GroupIndex int
+	Name       string
+}
+
+// EmptyNode matches the empty string. The parser produces it wherever an
+// operand is missing, as in a|, () or an empty pattern, and records in
+// Offset the byte position in the pattern where the operand would have been.
+type EmptyNode struct {
+	baseASTNode
+	Offset int
 }
 
 type AlternationNode struct {
@@ -28,24 +39,42 @@ type ConcatenationNode struct {
 	Right ASTNode
 }
 
+// Quantifier nodes are greedy by default. Lazy ones prefer the fewest
+// repetitions that still let the rest of the pattern match.
 type KleeneClosureNode struct {
 	baseASTNode
 	Child ASTNode
+	Lazy  bool
 }
 
 type PositiveClosureNode struct {
 	baseASTNode
 	Child ASTNode
+	Lazy  bool
 }
 
 type OptionalNode struct {
 	baseASTNode
 	Child ASTNode
+	Lazy  bool
 }
 
+// BoundedRepetitionNode repeats Child between Min and Max times.
+// Max is -1 when the repetition has no upper bound.
+type BoundedRepetitionNode struct {
+	baseASTNode
+	Child ASTNode
+	Min   int
+	Max   int
+	Lazy  bool
+}
+
+// Nodes that depend on the inline flags in effect where they appear record
+// the resulting mode themselves, so later stages never see the flags.
 type LiteralNode struct {
 	baseASTNode
-	Literal rune
+	Literal         rune
+	CaseInsensitive bool
 }
 
 type CharacterSetNode struct {
@@ -54,24 +83,219 @@ type CharacterSetNode struct {
 	Literals         []rune
 	Ranges           [][2]rune
 	CharacterClasses []predefinedclass.PredefinedClass
+	Properties       []predefinedclass.UnicodeProperty
+	CaseInsensitive  bool
+	Unicode          bool
+}
+
+// UnicodePropertyNode matches one character with (or, when the property is
+// negated, without) the given Unicode property.
+type UnicodePropertyNode struct {
+	baseASTNode
+	Property predefinedclass.UnicodeProperty
 }
 
 type WildcardNode struct {
 	baseASTNode
+	MatchNewline bool
 }
 
+// The class nodes below match ASCII characters only unless Unicode is set.
 type DigitNode struct {
 	baseASTNode
+	Unicode bool
 }
 
 type AlphaNumericNode struct {
 	baseASTNode
+	Unicode bool
+}
+
+type WhitespaceNode struct {
+	baseASTNode
+	Unicode bool
+}
+
+type NonDigitNode struct {
+	baseASTNode
+	Unicode bool
+}
+
+type NonAlphaNumericNode struct {
+	baseASTNode
+	Unicode bool
+}
+
+type NonWhitespaceNode struct {
+	baseASTNode
+	Unicode bool
 }
 
+// AtomicGroupNode matches Child once, committing to the first way it
+// matches. Possessive quantifiers are parsed as a quantifier wrapped in an
+// atomic group.
+type AtomicGroupNode struct {
+	baseASTNode
+	Child ASTNode
+}
+
+// LookaroundNode asserts, without consuming input, that Child matches
+// right after the current position (or, when Behind is set, right before
+// it). Negated inverts the assertion.
+type LookaroundNode struct {
+	baseASTNode
+	Child   ASTNode
+	Behind  bool
+	Negated bool
+}
+
+// BackReferenceNode matches the text most recently captured by the group
+// with the given index.
+type BackReferenceNode struct {
+	baseASTNode
+	GroupIndex      int
+	CaseInsensitive bool
+}
+
+// StartAnchorNode matches at the start of the input, and also after every
+// newline when Multiline is set.
 type StartAnchorNode struct {
 	baseASTNode
+	Multiline bool
 }
 
+// EndAnchorNode matches at the end of the input, and also before every
+// newline when Multiline is set or before a final newline when
+// BeforeFinalNewline is set.
 type EndAnchorNode struct {
 	baseASTNode
+	Multiline          bool
+	BeforeFinalNewline bool
+}
+
+// WordBoundaryNode and NonWordBoundaryNode judge word characters as \w
+// does, so Unicode has the same meaning as on AlphaNumericNode.
+type WordBoundaryNode struct {
+	baseASTNode
+	Unicode bool
+}
+
+type NonWordBoundaryNode struct {
+	baseASTNode
+	Unicode bool
+}
+
+// Length returns the minimum and maximum number of runes that n can match.
+// The maximum is -1 when it is unbounded, which is also the case for
+// back-references since the length of the captured text is not known
+// until the pattern runs.
+func Length(n ASTNode) (int, int) {
+	switch node := n.(type) {
+	case *LiteralNode, *CharacterSetNode, *WildcardNode,
+		*DigitNode, *AlphaNumericNode, *WhitespaceNode,
+		*NonDigitNode, *NonAlphaNumericNode, *NonWhitespaceNode,
+		*UnicodePropertyNode:
+		return 1, 1
+	case *EmptyNode, *StartAnchorNode, *EndAnchorNode, *WordBoundaryNode,
+		*NonWordBoundaryNode, *LookaroundNode:
+		return 0, 0
+	case *CaptureGroupNode:
+		return Length(node.Child)
+	case *AtomicGroupNode:
+		return Length(node.Child)
+	case *AlternationNode:
+		leftMin, leftMax := Length(node.Left)
+		rightMin, rightMax := Length(node.Right)
+		maxLength := max(leftMax, rightMax)
+		if leftMax == -1 || rightMax == -1 {
+			maxLength = -1
+		}
+		return min(leftMin, rightMin), maxLength
+	case *ConcatenationNode:
+		leftMin, leftMax := Length(node.Left)
+		rightMin, rightMax := Length(node.Right)
+		maxLength := leftMax + rightMax
+		if leftMax == -1 || rightMax == -1 {
+			maxLength = -1
+		}
+		return leftMin + rightMin, maxLength
+	case *KleeneClosureNode:
+		return 0, -1
+	case *PositiveClosureNode:
+		childMin, _ := Length(node.Child)
+		return childMin, -1
+	case *OptionalNode:
+		_, childMax := Length(node.Child)
+		return 0, childMax
+	case *BoundedRepetitionNode:
+		childMin, childMax := Length(node.Child)
+		if childMax == -1 || node.Max == -1 {
+			return childMin * node.Min, -1
+		}
+		return childMin * node.Min, childMax * node.Max
+	default:
+		// Back-references, and anything else whose length cannot be
+		// known before matching.
+		return 0, -1
+	}
+}
+
+// HasBackReference reports whether the tree contains a back-reference, in
+// which case it cannot be matched by the memoizing NFA simulator.
+func HasBackReference(n ASTNode) bool {
+	switch node := n.(type) {
+	case *BackReferenceNode:
+		return true
+	case *CaptureGroupNode:
+		return HasBackReference(node.Child)
+	case *AlternationNode:
+		return HasBackReference(node.Left) || HasBackReference(node.Right)
+	case *ConcatenationNode:
+		return HasBackReference(node.Left) || HasBackReference(node.Right)
+	case *KleeneClosureNode:
+		return HasBackReference(node.Child)
+	case *PositiveClosureNode:
+		return HasBackReference(node.Child)
+	case *OptionalNode:
+		return HasBackReference(node.Child)
+	case *BoundedRepetitionNode:
+		return HasBackReference(node.Child)
+	case *AtomicGroupNode:
+		return HasBackReference(node.Child)
+	case *LookaroundNode:
+		return HasBackReference(node.Child)
+	default:
+		return false
+	}
+}
+
+// ShiftGroups adds offset to the number of every capture group in the tree
+// and of every back-reference to one, so that a tree parsed on its own can
+// be joined to others whose groups come first.
+func ShiftGroups(n ASTNode, offset int) {
+	switch node := n.(type) {
+	case *BackReferenceNode:
+		node.GroupIndex += offset
+	case *CaptureGroupNode:
+		node.GroupIndex += offset
+		ShiftGroups(node.Child, offset)
+	case *AlternationNode:
+		ShiftGroups(node.Left, offset)
+		ShiftGroups(node.Right, offset)
+	case *ConcatenationNode:
+		ShiftGroups(node.Left, offset)
+		ShiftGroups(node.Right, offset)
+	case *KleeneClosureNode:
+		ShiftGroups(node.Child, offset)
+	case *PositiveClosureNode:
+		ShiftGroups(node.Child, offset)
+	case *OptionalNode:
+		ShiftGroups(node.Child, offset)
+	case *BoundedRepetitionNode:
+		ShiftGroups(node.Child, offset)
+	case *AtomicGroupNode:
+		ShiftGroups(node.Child, offset)
+	case *LookaroundNode:
+		ShiftGroups(node.Child, offset)
+	}
 }
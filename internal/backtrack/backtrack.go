@@ -1,54 +1,373 @@
 package backtrack
 
-// import (
-// 	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
-// 	"github.com/mmarchesotti/build-your-own-grep/internal/token"
-// )
-
-// func Run(line []byte, tokens []token.Token) (match bool, err error) {
-// 	return processTokens(line, 0, tokens, []nfasimulator.Capture{})
-// }
-
-// func processTokens(line []byte, lineIndex int, tokens []token.Token, allCapturedGroups []nfasimulator.Capture) (match bool, err error) {
-// 	for i, t := range tokens {
-// 		backReferenceToken, isBackreferenceToken := t.(*token.BackReference)
-// 		if !isBackreferenceToken {
-// 			continue
-// 		}
-
-// 		matchesChannel := nfasimulator.Simulate(line[lineIndex:], tokens[:i])
-// 		for match := range matchesChannel {
-// 			localLineIndex := lineIndex
-// 			allCapturedGroups = append(allCapturedGroups, match.capturedGroups)
-// 			backReferenceGroup, err := getReferencedGroup(allCapturedGroups, backReferenceToken)
-// 			if err != nil {
-// 				return false, err
-// 			}
-// 			backReferenceMatch, err := matchBackReference(line, localLineIndex, backReferenceGroup)
-// 			if err != nil {
-// 				return false, err
-// 			}
-// 			if !backReferenceMatch {
-// 				continue
-// 			}
-// 			localLineIndex += len(backReferenceGroup)
-// 			restOfPatternMatch, err := processTokens(line, localLineIndex, tokens[i+1:], captureGroup)
-// 			if err != nil {
-// 				return false, err
-// 			}
-// 			if restOfPatternMatch {
-// 				return true, nil
-// 			}
-// 		}
-// 		return false, nil
-// 	}
-// 	return Simulate(line[lineIndex:], tokens)
-// }
-
-// func getReferencedGroup(allCapturedGroups [][]byte, backReferenceToken BackReferenceToken) ([]byte, error) {
-// 	if backReferenceToken.CapturedGroupNumber > len(allCapturedGroups) {
-// 		return nil, fmt.Error("Reference to non-existing group '%d'", backReferenceToken.CapturedGroupNumber)
-// 	}
-// 	capturedGroupIndex := backReferenceToken.CapturedGroupNumber - 1
-// 	return allCapturedGroups[capturedGroupIndex], nil
-// }
+import (
+	"bytes"
+	"encoding/binary"
+	"iter"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/matcher"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfa"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
+)
+
+// machine walks the NFA depth first, trying Branch1 of every split before
+// Branch2. Unlike the NFA simulator it keys its visited set on captures as
+// well as the state and line index, because with back-references the
+// outcome of a state depends on what was captured before reaching it. Only
+// the groups that back-references refer to can change that outcome, so
+// only their captures are part of the key.
+type machine struct {
+	line     []byte
+	captures []nfasimulator.Capture
+	// tuple is the number given by tuples to the current captures.
+	tuple   int
+	tuples  *tupleTable
+	visited map[visit]bool
+	end     int
+	// requiredEnd, when not -1, is the only line index at which the
+	// accepting state may be reached. Lookbehinds use it to make their
+	// sub-automaton end at the position being tested.
+	requiredEnd int
+}
+
+// visit identifies a state reached at a line index with given captures of
+// the groups referred to by back-references. Every path through it has the
+// same future, so it only ever needs to be explored once.
+type visit struct {
+	state     nfa.State
+	lineIndex int
+	tuple     int
+}
+
+// tupleTable numbers the distinct captures of the groups referred to by
+// back-references, so that they can be part of a comparable visit.
+type tupleTable struct {
+	groups  []int
+	numbers map[string]int
+	buffer  []byte
+}
+
+func newTupleTable(start nfa.State) *tupleTable {
+	return &tupleTable{groups: referencedGroups(start), numbers: make(map[string]int)}
+}
+
+// number returns the number of the captures of the referenced groups.
+func (t *tupleTable) number(captures []nfasimulator.Capture) int {
+	t.buffer = t.buffer[:0]
+	for _, groupIndex := range t.groups {
+		t.buffer = binary.AppendVarint(t.buffer, int64(captures[groupIndex].Start))
+		t.buffer = binary.AppendVarint(t.buffer, int64(captures[groupIndex].End))
+	}
+	if number, ok := t.numbers[string(t.buffer)]; ok {
+		return number
+	}
+	number := len(t.numbers)
+	t.numbers[string(t.buffer)] = number
+	return number
+}
+
+// referencedGroups returns the groups that the back-references of the
+// automaton at start refer to.
+func referencedGroups(start nfa.State) []int {
+	var groups []int
+	seen := make(map[nfa.State]bool)
+	stack := []nfa.State{start}
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[state] {
+			continue
+		}
+		seen[state] = true
+
+		switch st := state.(type) {
+		case *nfa.SplitState:
+			stack = append(stack, st.Branch1, st.Branch2)
+		case *nfa.EpsilonState:
+			stack = append(stack, st.Out)
+		case *nfa.MatcherState:
+			stack = append(stack, st.Out)
+		case *nfa.CaptureStartState:
+			stack = append(stack, st.Out)
+		case *nfa.CaptureEndState:
+			stack = append(stack, st.Out)
+		case *nfa.BackReferenceState:
+			if !slices.Contains(groups, st.GroupIndex) {
+				groups = append(groups, st.GroupIndex)
+			}
+			stack = append(stack, st.Out)
+		case *nfa.AtomicGroupState:
+			stack = append(stack, st.Start, st.Out)
+		case *nfa.LookaroundState:
+			stack = append(stack, st.Start, st.Out)
+		case *nfa.StartAnchorState:
+			stack = append(stack, st.Out)
+		case *nfa.EndAnchorState:
+			stack = append(stack, st.Out)
+		case *nfa.WordBoundaryState:
+			stack = append(stack, st.Out)
+		case *nfa.NonWordBoundaryState:
+			stack = append(stack, st.Out)
+		}
+	}
+	return groups
+}
+
+// task is either a state to explore at a line index or, when isRevert is
+// set, a change to the captures to undo once everything pushed after it
+// has failed.
+type task struct {
+	state     nfa.State
+	lineIndex int
+	isRevert  bool
+	revert    undo
+}
+
+// undo restores the captures and their tuple number. When captures is not
+// nil it replaces them whole; otherwise the start or end of one group is
+// set back to oldValue.
+type undo struct {
+	captures     []nfasimulator.Capture
+	captureIndex int
+	isStart      bool
+	oldValue     int
+	tuple        int
+}
+
+// Simulate returns the successive matches of fragment in line, in the
+// order described by nfasimulator.Matches.
+//
+// As in the NFA simulator, the visits made by a search that found no match
+// cannot lead to one from a later start either, so they are only
+// forgotten once a match is found.
+func Simulate(line []byte, fragment nfa.Fragment, captureCount int, groupNames map[string]int) (iter.Seq[nfasimulator.MatchResult], error) {
+	return func(yield func(nfasimulator.MatchResult) bool) {
+		tuples := newTupleTable(fragment.Start)
+		visited := make(map[visit]bool)
+		results := nfasimulator.Matches(line, groupNames, func(startIndex int) ([]nfasimulator.Capture, bool) {
+			captures, found := findMatchAt(fragment.Start, line, startIndex, captureCount, tuples, visited)
+			if found {
+				clear(visited)
+			}
+			return captures, found
+		})
+		for result := range results {
+			if !yield(result) {
+				return
+			}
+		}
+	}, nil
+}
+
+func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount int, tuples *tupleTable, visited map[visit]bool) ([]nfasimulator.Capture, bool) {
+	m := &machine{
+		line:        line,
+		captures:    make([]nfasimulator.Capture, captureCount),
+		tuples:      tuples,
+		visited:     visited,
+		requiredEnd: -1,
+	}
+	for i := range m.captures {
+		m.captures[i] = nfasimulator.Capture{Start: -1, End: -1}
+	}
+	m.tuple = tuples.number(m.captures)
+
+	if !m.run(startState, startIndex) {
+		return nil, false
+	}
+
+	captures := make([]nfasimulator.Capture, len(m.captures))
+	copy(captures, m.captures)
+	return captures, true
+}
+
+// subMachine returns a machine for running the sub-automaton of an atomic
+// group or lookaround, starting from a copy of the current captures.
+func (m *machine) subMachine(requiredEnd int) *machine {
+	sub := &machine{
+		line:        m.line,
+		captures:    make([]nfasimulator.Capture, len(m.captures)),
+		tuple:       m.tuple,
+		tuples:      m.tuples,
+		visited:     make(map[visit]bool),
+		requiredEnd: requiredEnd,
+	}
+	copy(sub.captures, m.captures)
+	return sub
+}
+
+// setCapture sets the start or end of a group to lineIndex, returning how
+// to undo it.
+func (m *machine) setCapture(groupIndex int, isStart bool, lineIndex int) undo {
+	u := undo{captureIndex: groupIndex, isStart: isStart, tuple: m.tuple}
+	if isStart {
+		u.oldValue = m.captures[groupIndex].Start
+		m.captures[groupIndex].Start = lineIndex
+	} else {
+		u.oldValue = m.captures[groupIndex].End
+		m.captures[groupIndex].End = lineIndex
+	}
+	m.tuple = m.tuples.number(m.captures)
+	return u
+}
+
+// replaceCaptures replaces the captures with those of a sub-automaton,
+// returning how to undo it.
+func (m *machine) replaceCaptures(captures []nfasimulator.Capture) undo {
+	u := undo{captures: m.captures, tuple: m.tuple}
+	m.captures = captures
+	m.tuple = m.tuples.number(m.captures)
+	return u
+}
+
+func (m *machine) restore(u undo) {
+	switch {
+	case u.captures != nil:
+		m.captures = u.captures
+	case u.isStart:
+		m.captures[u.captureIndex].Start = u.oldValue
+	default:
+		m.captures[u.captureIndex].End = u.oldValue
+	}
+	m.tuple = u.tuple
+}
+
+// run reports whether the accepting state can be reached from state at
+// lineIndex. On success the captures of the winning path are left in place;
+// on failure they are restored to what they were on entry. The paths are
+// explored with an explicit stack rather than by recursion, so a long line
+// cannot exhaust the call stack.
+func (m *machine) run(state nfa.State, lineIndex int) bool {
+	stack := []task{{state: state, lineIndex: lineIndex}}
+	push := func(state nfa.State, lineIndex int) {
+		stack = append(stack, task{state: state, lineIndex: lineIndex})
+	}
+	pushRevert := func(u undo) {
+		stack = append(stack, task{isRevert: true, revert: u})
+	}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if current.isRevert {
+			m.restore(current.revert)
+			continue
+		}
+
+		key := visit{state: current.state, lineIndex: current.lineIndex, tuple: m.tuple}
+		if m.visited[key] {
+			continue
+		}
+		m.visited[key] = true
+
+		lineIndex := current.lineIndex
+		switch st := current.state.(type) {
+		case *nfa.AcceptingState:
+			if m.requiredEnd != -1 && lineIndex != m.requiredEnd {
+				continue
+			}
+			m.end = lineIndex
+			return true
+		case *nfa.AtomicGroupState:
+			group := m.subMachine(-1)
+			if group.run(st.Start, lineIndex) {
+				pushRevert(m.replaceCaptures(group.captures))
+				push(st.Out, group.end)
+			}
+		case *nfa.MatcherState:
+			if lineIndex >= len(m.line) {
+				continue
+			}
+			r, size := utf8.DecodeRune(m.line[lineIndex:])
+			if match, _ := st.Matcher.Match(r); match {
+				push(st.Out, lineIndex+size)
+			}
+		case *nfa.EpsilonState:
+			push(st.Out, lineIndex)
+		case *nfa.SplitState:
+			push(st.Branch2, lineIndex)
+			push(st.Branch1, lineIndex)
+		case *nfa.CaptureStartState:
+			pushRevert(m.setCapture(st.GroupIndex, true, lineIndex))
+			push(st.Out, lineIndex)
+		case *nfa.CaptureEndState:
+			pushRevert(m.setCapture(st.GroupIndex, false, lineIndex))
+			push(st.Out, lineIndex)
+		case *nfa.BackReferenceState:
+			group := m.captures[st.GroupIndex]
+			if group.Start == -1 || group.End == -1 {
+				continue
+			}
+			captured := m.line[group.Start:group.End]
+			if !st.CaseInsensitive {
+				if bytes.HasPrefix(m.line[lineIndex:], captured) {
+					push(st.Out, lineIndex+len(captured))
+				}
+				continue
+			}
+			if length, ok := hasPrefixFold(m.line[lineIndex:], captured); ok {
+				push(st.Out, lineIndex+length)
+			}
+		case *nfa.LookaroundState:
+			requiredEnd := -1
+			if st.Behind {
+				requiredEnd = lineIndex
+			}
+			var lookaround *machine
+			for _, start := range st.Starts(m.line, lineIndex) {
+				candidate := m.subMachine(requiredEnd)
+				if candidate.run(st.Start, start) {
+					lookaround = candidate
+					break
+				}
+			}
+			if (lookaround != nil) == st.Negated {
+				continue
+			}
+			if !st.Negated {
+				pushRevert(m.replaceCaptures(lookaround.captures))
+			}
+			push(st.Out, lineIndex)
+		case *nfa.StartAnchorState:
+			if st.Matches(m.line, lineIndex) {
+				push(st.Out, lineIndex)
+			}
+		case *nfa.EndAnchorState:
+			if st.Matches(m.line, lineIndex) {
+				push(st.Out, lineIndex)
+			}
+		case *nfa.WordBoundaryState:
+			if nfa.AtWordBoundary(m.line, lineIndex, st.Unicode) {
+				push(st.Out, lineIndex)
+			}
+		case *nfa.NonWordBoundaryState:
+			if !nfa.AtWordBoundary(m.line, lineIndex, st.Unicode) {
+				push(st.Out, lineIndex)
+			}
+		}
+	}
+	return false
+}
+
+// hasPrefixFold reports whether text starts with prefix under simple case
+// folding, and if so how many bytes of text the prefix spans. The byte
+// lengths can differ because a character and its other cases need not
+// have the same UTF-8 length.
+func hasPrefixFold(text, prefix []byte) (int, bool) {
+	textIndex := 0
+	for len(prefix) > 0 {
+		if textIndex >= len(text) {
+			return 0, false
+		}
+		want, wantSize := utf8.DecodeRune(prefix)
+		got, gotSize := utf8.DecodeRune(text[textIndex:])
+		if !matcher.EqualFold(got, want) {
+			return 0, false
+		}
+		prefix = prefix[wantSize:]
+		textIndex += gotSize
+	}
+	return textIndex, true
+}
@@ -0,0 +1,77 @@
+package backtrack
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/buildnfa"
+	"github.com/mmarchesotti/build-your-own-grep/internal/lexer"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
+	"github.com/mmarchesotti/build-your-own-grep/internal/parser"
+)
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		line     string
+		expected [][]nfasimulator.Capture
+	}{
+		{
+			name:    "every match of a back-reference",
+			pattern: `(\w)\1`,
+			line:    "aabcc",
+			expected: [][]nfasimulator.Capture{
+				{{Start: 0, End: 2}, {Start: 0, End: 1}},
+				{{Start: 3, End: 5}, {Start: 3, End: 4}},
+			},
+		},
+		{
+			name:     "reference to a group that did not participate",
+			pattern:  `(a)?b\1`,
+			line:     "b",
+			expected: nil,
+		},
+		{
+			name:    "empty loop terminates",
+			pattern: `^(a*)*\1$`,
+			line:    "aa",
+			expected: [][]nfasimulator.Capture{
+				{{Start: 0, End: 2}, {Start: 2, End: 2}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(tt.pattern)
+			if err != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", err)
+			}
+			tree, captureCount, groupNames, err := parser.Parse(tokens)
+			if err != nil {
+				t.Fatalf("Parse() returned an unexpected error: %v", err)
+			}
+			fragment, err := buildnfa.Build(tree)
+			if err != nil {
+				t.Fatalf("Build() returned an unexpected error: %v", err)
+			}
+
+			results, err := Simulate([]byte(tt.line), fragment, captureCount, groupNames)
+			if err != nil {
+				t.Fatalf("Simulate() returned an unexpected error: %v", err)
+			}
+
+			var actual [][]nfasimulator.Capture
+			for result := range results {
+				actual = append(actual, result.Captures)
+			}
+
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("Simulate() for pattern '%s' on '%s' failed", tt.pattern, tt.line)
+				t.Errorf("got:  %v", actual)
+				t.Errorf("want: %v", tt.expected)
+			}
+		})
+	}
+}
@@ -20,6 +20,127 @@ func newMatcherFragment(m matcher.Matcher) nfa.Fragment {
 	}
 }
 
+// newPredefinedClassMatcher returns the matcher for class. Unicode selects
+// the Unicode definitions of \d, \w and \s; POSIX classes are always ASCII.
+func newPredefinedClassMatcher(class predefinedclass.PredefinedClass, unicode bool) (matcher.PredefinedClassMatcher, error) {
+	switch class {
+	case predefinedclass.ClassDigit:
+		return &matcher.DigitMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassAlphanumeric:
+		return &matcher.AlphaNumericMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassWhitespace:
+		return &matcher.WhitespaceMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassNonDigit:
+		return &matcher.NonDigitMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassNonAlphanumeric:
+		return &matcher.NonAlphaNumericMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassNonWhitespace:
+		return &matcher.NonWhitespaceMatcher{Unicode: unicode}, nil
+	case predefinedclass.ClassPosixAlnum:
+		return &matcher.PosixAlnumMatcher{}, nil
+	case predefinedclass.ClassPosixAlpha:
+		return &matcher.PosixAlphaMatcher{}, nil
+	case predefinedclass.ClassPosixBlank:
+		return &matcher.PosixBlankMatcher{}, nil
+	case predefinedclass.ClassPosixCntrl:
+		return &matcher.PosixCntrlMatcher{}, nil
+	case predefinedclass.ClassPosixDigit:
+		return &matcher.PosixDigitMatcher{}, nil
+	case predefinedclass.ClassPosixGraph:
+		return &matcher.PosixGraphMatcher{}, nil
+	case predefinedclass.ClassPosixLower:
+		return &matcher.PosixLowerMatcher{}, nil
+	case predefinedclass.ClassPosixPrint:
+		return &matcher.PosixPrintMatcher{}, nil
+	case predefinedclass.ClassPosixPunct:
+		return &matcher.PosixPunctMatcher{}, nil
+	case predefinedclass.ClassPosixSpace:
+		return &matcher.PosixSpaceMatcher{}, nil
+	case predefinedclass.ClassPosixUpper:
+		return &matcher.PosixUpperMatcher{}, nil
+	case predefinedclass.ClassPosixXdigit:
+		return &matcher.PosixXdigitMatcher{}, nil
+	default:
+		return nil, fmt.Errorf("unexpected predefined class %d", class)
+	}
+}
+
+func newUnicodePropertyMatcher(property predefinedclass.UnicodeProperty) (matcher.PredefinedClassMatcher, error) {
+	table, ok := predefinedclass.PropertyTable(property.Name)
+	if !ok {
+		return nil, fmt.Errorf("unknown Unicode property %s", property.Name)
+	}
+	return &matcher.UnicodePropertyMatcher{Table: table, Negated: property.Negated}, nil
+}
+
+// newQuantifierSplit returns a split that prefers entering the quantified
+// expression, or leaving it when lazy, together with a pointer to the
+// branch that continues after the quantifier.
+func newQuantifierSplit(body nfa.State, lazy bool) (*nfa.SplitState, *nfa.State) {
+	if lazy {
+		split := &nfa.SplitState{
+			Branch1: nil,
+			Branch2: body,
+		}
+		return split, &split.Branch1
+	}
+	split := &nfa.SplitState{
+		Branch1: body,
+		Branch2: nil,
+	}
+	return split, &split.Branch2
+}
+
+func newEpsilonFragment() nfa.Fragment {
+	state := nfa.EpsilonState{
+		Out: nil,
+	}
+	return nfa.Fragment{
+		Start: &state,
+		Out:   []*nfa.State{&state.Out},
+	}
+}
+
+// expandRepetition rewrites a bounded repetition in terms of concatenation,
+// Kleene closure and optional nodes. Each occurrence of the child is turned
+// into its own sub-fragment by processNode, so capture groups inside the
+// repeated expression are cloned for every repetition.
+func expandRepetition(node *ast.BoundedRepetitionNode) ast.ASTNode {
+	var tail ast.ASTNode
+	if node.Max == -1 {
+		tail = &ast.KleeneClosureNode{Child: node.Child, Lazy: node.Lazy}
+	} else {
+		for i := node.Min; i < node.Max; i++ {
+			if tail == nil {
+				tail = &ast.OptionalNode{Child: node.Child, Lazy: node.Lazy}
+			} else {
+				tail = &ast.OptionalNode{
+					Child: &ast.ConcatenationNode{Left: node.Child, Right: tail},
+					Lazy:  node.Lazy,
+				}
+			}
+		}
+	}
+
+	var expanded ast.ASTNode
+	for i := 0; i < node.Min; i++ {
+		if expanded == nil {
+			expanded = node.Child
+		} else {
+			expanded = &ast.ConcatenationNode{Left: expanded, Right: node.Child}
+		}
+	}
+
+	switch {
+	case expanded == nil:
+		return tail
+	case tail == nil:
+		return expanded
+	default:
+		return &ast.ConcatenationNode{Left: expanded, Right: tail}
+	}
+}
+
 func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 	switch node := n.(type) {
 	case *ast.CaptureGroupNode:
@@ -43,6 +164,8 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 			Start: startState,
 			Out:   []*nfa.State{&endState.Out},
 		}, nil
+	case *ast.EmptyNode:
+		return newEpsilonFragment(), nil
 	case *ast.AlternationNode:
 		subfragment1, err1 := processNode(node.Left)
 		if err1 != nil {
@@ -80,14 +203,11 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 		if err != nil {
 			return nfa.Fragment{}, err
 		}
-		split := nfa.SplitState{
-			Branch1: subfragment.Start,
-			Branch2: nil,
-		}
-		nfa.SetStates(subfragment.Out, &split)
+		split, exit := newQuantifierSplit(subfragment.Start, node.Lazy)
+		nfa.SetStates(subfragment.Out, split)
 		frag := nfa.Fragment{
-			Start: &split,
-			Out:   []*nfa.State{&split.Branch2},
+			Start: split,
+			Out:   []*nfa.State{exit},
 		}
 		return frag, nil
 	case *ast.PositiveClosureNode:
@@ -95,14 +215,11 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 		if err != nil {
 			return nfa.Fragment{}, err
 		}
-		split := nfa.SplitState{
-			Branch1: subfragment.Start,
-			Branch2: nil,
-		}
-		nfa.SetStates(subfragment.Out, &split)
+		split, exit := newQuantifierSplit(subfragment.Start, node.Lazy)
+		nfa.SetStates(subfragment.Out, split)
 		frag := nfa.Fragment{
 			Start: subfragment.Start,
-			Out:   []*nfa.State{&split.Branch2},
+			Out:   []*nfa.State{exit},
 		}
 		return frag, nil
 	case *ast.OptionalNode:
@@ -110,24 +227,70 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 		if err != nil {
 			return nfa.Fragment{}, err
 		}
-		split := nfa.SplitState{
-			Branch1: subfragment.Start,
-			Branch2: nil,
+		split, exit := newQuantifierSplit(subfragment.Start, node.Lazy)
+		frag := nfa.Fragment{
+			Start: split,
+			Out:   append(subfragment.Out, exit),
+		}
+		return frag, nil
+	case *ast.BoundedRepetitionNode:
+		expanded := expandRepetition(node)
+		if expanded == nil {
+			return newEpsilonFragment(), nil
+		}
+		return processNode(expanded)
+	case *ast.AtomicGroupNode:
+		subfragment, err := processNode(node.Child)
+		if err != nil {
+			return nfa.Fragment{}, err
+		}
+		nfa.SetStates(subfragment.Out, &nfa.AcceptingState{})
+		s := &nfa.AtomicGroupState{
+			Start: subfragment.Start,
+			Out:   nil,
+		}
+		frag := nfa.Fragment{
+			Start: s,
+			Out:   []*nfa.State{&s.Out},
+		}
+		return frag, nil
+	case *ast.LookaroundNode:
+		subfragment, err := processNode(node.Child)
+		if err != nil {
+			return nfa.Fragment{}, err
+		}
+		nfa.SetStates(subfragment.Out, &nfa.AcceptingState{})
+		s := &nfa.LookaroundState{
+			Start:   subfragment.Start,
+			Out:     nil,
+			Behind:  node.Behind,
+			Negated: node.Negated,
+		}
+		if node.Behind {
+			// The parser rejects lookbehinds of unbounded length, so
+			// maxLength is never -1 here.
+			minLength, maxLength := ast.Length(node.Child)
+			s.MinLength = minLength
+			s.MaxLength = maxLength
 		}
 		frag := nfa.Fragment{
-			Start: &split,
-			Out:   append(subfragment.Out, &split.Branch2),
+			Start: s,
+			Out:   []*nfa.State{&s.Out},
 		}
 		return frag, nil
 	case *ast.CharacterSetNode:
 		var characterClassesMatchers []matcher.PredefinedClassMatcher
 		for _, characterClass := range node.CharacterClasses {
-			var m matcher.PredefinedClassMatcher
-			switch characterClass {
-			case predefinedclass.ClassDigit:
-				m = &matcher.DigitMatcher{}
-			case predefinedclass.ClassAlphanumeric:
-				m = &matcher.AlphaNumericMatcher{}
+			m, err := newPredefinedClassMatcher(characterClass, node.Unicode)
+			if err != nil {
+				return nfa.Fragment{}, err
+			}
+			characterClassesMatchers = append(characterClassesMatchers, m)
+		}
+		for _, property := range node.Properties {
+			m, err := newUnicodePropertyMatcher(property)
+			if err != nil {
+				return nfa.Fragment{}, err
 			}
 			characterClassesMatchers = append(characterClassesMatchers, m)
 		}
@@ -137,18 +300,50 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 			Ranges:                   node.Ranges,
 			CharacterClassesMatchers: characterClassesMatchers,
 		}
+		if node.CaseInsensitive {
+			return newMatcherFragment(&matcher.CaseFoldedCharacterSetMatcher{Set: characterSetMatcher}), nil
+		}
 		return newMatcherFragment(characterSetMatcher), nil
 	case *ast.LiteralNode:
+		if node.CaseInsensitive {
+			return newMatcherFragment(&matcher.CaseFoldedLiteralMatcher{Literal: node.Literal}), nil
+		}
 		return newMatcherFragment(&matcher.LiteralMatcher{Literal: node.Literal}), nil
 	case *ast.WildcardNode:
-		return newMatcherFragment(&matcher.WildcardMatcher{}), nil
+		return newMatcherFragment(&matcher.WildcardMatcher{MatchNewline: node.MatchNewline}), nil
 	case *ast.DigitNode:
-		return newMatcherFragment(&matcher.DigitMatcher{}), nil
+		return newMatcherFragment(&matcher.DigitMatcher{Unicode: node.Unicode}), nil
 	case *ast.AlphaNumericNode:
-		return newMatcherFragment(&matcher.AlphaNumericMatcher{}), nil
+		return newMatcherFragment(&matcher.AlphaNumericMatcher{Unicode: node.Unicode}), nil
+	case *ast.WhitespaceNode:
+		return newMatcherFragment(&matcher.WhitespaceMatcher{Unicode: node.Unicode}), nil
+	case *ast.NonDigitNode:
+		return newMatcherFragment(&matcher.NonDigitMatcher{Unicode: node.Unicode}), nil
+	case *ast.NonAlphaNumericNode:
+		return newMatcherFragment(&matcher.NonAlphaNumericMatcher{Unicode: node.Unicode}), nil
+	case *ast.NonWhitespaceNode:
+		return newMatcherFragment(&matcher.NonWhitespaceMatcher{Unicode: node.Unicode}), nil
+	case *ast.UnicodePropertyNode:
+		m, err := newUnicodePropertyMatcher(node.Property)
+		if err != nil {
+			return nfa.Fragment{}, err
+		}
+		return newMatcherFragment(m), nil
+	case *ast.BackReferenceNode:
+		s := &nfa.BackReferenceState{
+			Out:             nil,
+			GroupIndex:      node.GroupIndex,
+			CaseInsensitive: node.CaseInsensitive,
+		}
+		frag := nfa.Fragment{
+			Start: s,
+			Out:   []*nfa.State{&s.Out},
+		}
+		return frag, nil
 	case *ast.StartAnchorNode:
 		s := &nfa.StartAnchorState{
-			Out: nil,
+			Out:       nil,
+			Multiline: node.Multiline,
 		}
 		frag := nfa.Fragment{
 			Start: s,
@@ -157,7 +352,29 @@ func processNode(n ast.ASTNode) (nfa.Fragment, error) {
 		return frag, nil
 	case *ast.EndAnchorNode:
 		s := &nfa.EndAnchorState{
-			Out: nil,
+			Out:                nil,
+			Multiline:          node.Multiline,
+			BeforeFinalNewline: node.BeforeFinalNewline,
+		}
+		frag := nfa.Fragment{
+			Start: s,
+			Out:   []*nfa.State{&s.Out},
+		}
+		return frag, nil
+	case *ast.WordBoundaryNode:
+		s := &nfa.WordBoundaryState{
+			Out:     nil,
+			Unicode: node.Unicode,
+		}
+		frag := nfa.Fragment{
+			Start: s,
+			Out:   []*nfa.State{&s.Out},
+		}
+		return frag, nil
+	case *ast.NonWordBoundaryNode:
+		s := &nfa.NonWordBoundaryState{
+			Out:     nil,
+			Unicode: node.Unicode,
 		}
 		frag := nfa.Fragment{
 			Start: s,
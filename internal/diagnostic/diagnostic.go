@@ -0,0 +1,120 @@
+package diagnostic
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Code identifies the kind of problem a PatternError reports, in a form
+// that does not change when the wording of the message does.
+type Code string
+
+const (
+	CodeInvalidUTF8            Code = "invalid-utf8"
+	CodeDanglingBackslash      Code = "dangling-backslash"
+	CodeUnknownEscape          Code = "unknown-escape"
+	CodeInvalidEscape          Code = "invalid-escape"
+	CodeInvalidCodePoint       Code = "invalid-code-point"
+	CodeUnknownProperty        Code = "unknown-property"
+	CodeInvalidProperty        Code = "invalid-property"
+	CodeUnmatchedBracket       Code = "unmatched-bracket"
+	CodeInvalidRange           Code = "invalid-range"
+	CodeUnknownPosixClass      Code = "unknown-posix-class"
+	CodeInvalidGroupSyntax     Code = "invalid-group-syntax"
+	CodeInvalidGroupName       Code = "invalid-group-name"
+	CodeDuplicateGroupName     Code = "duplicate-group-name"
+	CodeInvalidBackReference   Code = "invalid-back-reference"
+	CodeMissingGroup           Code = "missing-group"
+	CodeInvalidRepetition      Code = "invalid-repetition"
+	CodeRepetitionTooLarge     Code = "repetition-too-large"
+	CodeNothingToRepeat        Code = "nothing-to-repeat"
+	CodeNestedQuantifier       Code = "nested-quantifier"
+	CodeQuantifiedAssertion    Code = "quantified-assertion"
+	CodeUnboundedLookbehind    Code = "unbounded-lookbehind"
+	CodeUnmatchedGroupOpener   Code = "unmatched-group-opener"
+	CodeUnmatchedGroupCloser   Code = "unmatched-group-closer"
+	CodeUnexpectedToken        Code = "unexpected-token"
+	CodeUnexpectedEndOfPattern Code = "unexpected-end-of-pattern"
+)
+
+// PatternError is a problem in a pattern, located by the byte offset and
+// length of the text it concerns.
+type PatternError struct {
+	Offset  int
+	Length  int
+	Code    Code
+	Message string
+}
+
+func (e *PatternError) Error() string {
+	return e.Message
+}
+
+// Errorf returns a PatternError for the length bytes of the pattern
+// starting at offset, with a message formatted as by fmt.Sprintf.
+func Errorf(code Code, offset, length int, format string, args ...any) *PatternError {
+	return &PatternError{
+		Offset:  offset,
+		Length:  length,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// ErrorList holds every error found in a pattern, in the order they were
+// found.
+type ErrorList []*PatternError
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Err returns l as an error, or nil when it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Caret returns the line of pattern that err points into, followed by a
+// line with a caret under the start of the offending text and tildes under
+// the rest of it. Tabs are copied into the second line so that the caret
+// stays aligned.
+func Caret(pattern string, err *PatternError) string {
+	offset := min(max(err.Offset, 0), len(pattern))
+	lineStart := strings.LastIndexByte(pattern[:offset], '\n') + 1
+	lineEnd := len(pattern)
+	if newline := strings.IndexByte(pattern[offset:], '\n'); newline != -1 {
+		lineEnd = offset + newline
+	}
+	end := min(offset+err.Length, lineEnd)
+
+	var marker strings.Builder
+	for _, r := range pattern[lineStart:offset] {
+		if r == '\t' {
+			marker.WriteByte('\t')
+		} else {
+			marker.WriteByte(' ')
+		}
+	}
+	marker.WriteByte('^')
+	if width := utf8.RuneCountInString(pattern[offset:end]); width > 1 {
+		marker.WriteString(strings.Repeat("~", width-1))
+	}
+
+	return pattern[lineStart:lineEnd] + "\n" + marker.String()
+}
@@ -0,0 +1,145 @@
+package lexer
+
+import (
+	"strings"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
+	"github.com/mmarchesotti/build-your-own-grep/internal/token"
+)
+
+// TokenizeBasic tokenizes inputPattern as a POSIX basic regular expression
+// (BRE), as read by grep without -E. In a BRE, \( \) \{ \} and \| are the
+// grouping, repetition and alternation operators, while ( ) { } | + and ?
+// stand for themselves. A * at the start of an expression, ^ anywhere but
+// at the start and $ anywhere but at the end are literals too. Every other
+// construct, including escapes and character sets, is read as by
+// TokenizeWithFlags.
+//
+// The pattern is rewritten into the extended syntax and tokenized by
+// TokenizeWithFlags, so both dialects produce the same tokens. Spans and
+// error locations refer to inputPattern.
+func TokenizeBasic(inputPattern string, flags token.Flags) ([]token.Token, error) {
+	if errs := checkUTF8(inputPattern); len(errs) > 0 {
+		return nil, errs
+	}
+
+	extended, offsets := translateBasic(inputPattern)
+	tokens, err := TokenizeWithFlags(extended, flags)
+	if err != nil {
+		if errs, ok := err.(diagnostic.ErrorList); ok {
+			for _, patternErr := range errs {
+				span := mapSpan(offsets, token.Span{Offset: patternErr.Offset, Length: patternErr.Length})
+				patternErr.Offset, patternErr.Length = span.Offset, span.Length
+			}
+		}
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		t.SetSpan(mapSpan(offsets, t.Span()))
+	}
+	return tokens, nil
+}
+
+// translateBasic rewrites the BRE pattern into the extended syntax. The
+// returned offsets hold, for each byte of the result and for its end, the
+// offset in pattern of the text it was written for.
+func translateBasic(pattern string) (string, []int) {
+	var out strings.Builder
+	offsets := make([]int, 0, len(pattern)+1)
+	emit := func(text string, offset int) {
+		out.WriteString(text)
+		for range len(text) {
+			offsets = append(offsets, offset)
+		}
+	}
+	copyText := func(start, end int) {
+		out.WriteString(pattern[start:end])
+		for i := start; i < end; i++ {
+			offsets = append(offsets, i)
+		}
+	}
+
+	// expressionStart is set where an expression begins: at the start of
+	// the pattern, after \( or \|, and after a leading ^.
+	expressionStart := true
+	for i := 0; i < len(pattern); {
+		atStart := expressionStart
+		expressionStart = false
+
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			end := i + 2
+			switch next := pattern[i+1]; next {
+			case '(', '|':
+				emit(string(next), i)
+				expressionStart = true
+			case ')', '{', '}':
+				emit(string(next), i)
+			case 'Q':
+				end = len(pattern)
+				if quoteEnd := strings.Index(pattern[i+2:], `\E`); quoteEnd != -1 {
+					end = i + 2 + quoteEnd + 2
+				}
+				copyText(i, end)
+			case 'p', 'P', 'x', 'o':
+				// Braces that are part of the escape keep their meaning.
+				if end < len(pattern) && pattern[end] == '{' {
+					if closer := strings.IndexByte(pattern[end:], '}'); closer != -1 {
+						end += closer + 1
+					}
+				}
+				copyText(i, end)
+			default:
+				copyText(i, end)
+			}
+			i = end
+		case c == '[':
+			_, length, _ := lexCharacterSet(pattern[i:])
+			copyText(i, i+length)
+			i += length
+		case c == '*' && atStart:
+			emit(`\*`, i)
+			i++
+		case c == '^':
+			if atStart {
+				emit("^", i)
+				expressionStart = true
+			} else {
+				emit(`\^`, i)
+			}
+			i++
+		case c == '$':
+			rest := pattern[i+1:]
+			if rest == "" || strings.HasPrefix(rest, `\)`) || strings.HasPrefix(rest, `\|`) {
+				emit("$", i)
+			} else {
+				emit(`\$`, i)
+			}
+			i++
+		case strings.IndexByte("(){}|+?", c) != -1:
+			emit(`\`+string(c), i)
+			i++
+		default:
+			copyText(i, i+1)
+			i++
+		}
+	}
+	offsets = append(offsets, len(pattern))
+
+	return out.String(), offsets
+}
+
+// mapSpan converts a span of the translated pattern into the span of the
+// original text it was written for.
+func mapSpan(offsets []int, span token.Span) token.Span {
+	last := len(offsets) - 1
+	start := offsets[min(span.Offset, last)]
+	end := offsets[min(span.End(), last)]
+	if span.Length > 0 && end == start {
+		// The span covers only part of the text written for a single
+		// character, such as the \ of the \+ written for a literal +.
+		end = start + 1
+	}
+	return token.Span{Offset: start, Length: end - start}
+}
@@ -0,0 +1,108 @@
+package lexer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
+	"github.com/mmarchesotti/build-your-own-grep/internal/token"
+)
+
+func TestTokenizeBasic(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		extended string
+	}{
+		{name: "literals", input: `abc`, extended: `abc`},
+		{name: "group", input: `\(ab\)*`, extended: `(ab)*`},
+		{name: "alternation", input: `cat\|dog`, extended: `cat|dog`},
+		{name: "bounded repetition", input: `a\{2,3\}`, extended: `a{2,3}`},
+		{name: "back-reference", input: `\(a\)\1`, extended: `(a)\1`},
+		{name: "operators of the extended syntax are literals", input: `(a|b)+c?{2}`, extended: `\(a\|b\)\+c\?\{2\}`},
+		{name: "star is not lazy after a quantifier", input: `a*?`, extended: `a*\?`},
+		{name: "leading star", input: `*a`, extended: `\*a`},
+		{name: "star after an anchor", input: `^*a`, extended: `^\*a`},
+		{name: "star at the start of a group", input: `\(*a\)`, extended: `(\*a)`},
+		{name: "star at the start of an alternative", input: `a\|*b`, extended: `a|\*b`},
+		{name: "inner caret", input: `a^b`, extended: `a\^b`},
+		{name: "caret at the start of a group", input: `\(^a\)`, extended: `(^a)`},
+		{name: "inner dollar", input: `a$b$`, extended: `a\$b$`},
+		{name: "dollar at the end of a group", input: `\(a$\)`, extended: `(a$)`},
+		{name: "character set", input: `[(|)]+`, extended: `[(|)]\+`},
+		{name: "escapes", input: `\d\x{41}\p{Greek}`, extended: `\d\x{41}\p{Greek}`},
+		{name: "quoted text", input: `\Q(a)\E+`, extended: `\Q(a)\E\+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := TokenizeBasic(tt.input, 0)
+			if err != nil {
+				t.Fatalf("TokenizeBasic() returned an unexpected error: %v", err)
+			}
+			expected, err := Tokenize(tt.extended)
+			if err != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", err)
+			}
+			clearSpans(actual)
+			clearSpans(expected)
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("got:  %#v", actual)
+				t.Errorf("want: %#v", expected)
+			}
+		})
+	}
+}
+
+func TestTokenizeBasicSpans(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Span
+	}{
+		{
+			input:    `\(a+\)`,
+			expected: []token.Span{{Offset: 0, Length: 2}, {Offset: 2, Length: 1}, {Offset: 3, Length: 1}, {Offset: 4, Length: 2}},
+		},
+		{
+			input:    `a\{2,3\}é`,
+			expected: []token.Span{{Offset: 0, Length: 1}, {Offset: 1, Length: 7}, {Offset: 8, Length: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := TokenizeBasic(tt.input, 0)
+			if err != nil {
+				t.Fatalf("TokenizeBasic() returned an unexpected error: %v", err)
+			}
+			actual := make([]token.Span, len(tokens))
+			for i, tok := range tokens {
+				actual[i] = tok.Span()
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("got:  %v", actual)
+				t.Errorf("want: %v", tt.expected)
+			}
+		})
+	}
+}
+
+func TestTokenizeBasicErrors(t *testing.T) {
+	_, err := TokenizeBasic(`(a\q\{3,1\}`, 0)
+	var errs diagnostic.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("TokenizeBasic() returned %v, want a diagnostic.ErrorList", err)
+	}
+	expected := diagnostic.ErrorList{
+		{Offset: 2, Length: 2, Code: diagnostic.CodeUnknownEscape, Message: `unknown escape sequence \q`},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		for _, e := range errs {
+			t.Errorf("got:  %+v", *e)
+		}
+		for _, e := range expected {
+			t.Errorf("want: %+v", *e)
+		}
+	}
+}
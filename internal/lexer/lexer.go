@@ -1,80 +1,135 @@
 package lexer
 
 import (
-	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
 	"github.com/mmarchesotti/build-your-own-grep/internal/predefinedclass"
 	"github.com/mmarchesotti/build-your-own-grep/internal/token"
 )
 
 func Tokenize(inputPattern string) ([]token.Token, error) {
-	tokens := make([]token.Token, 0, len(inputPattern))
+	return TokenizeWithFlags(inputPattern, 0)
+}
+
+// TokenizeWithFlags tokenizes inputPattern as if it started with an inline
+// modifier turning flags on. The flags are passed on to the parser as a
+// leading SetFlags token.
+//
+// Every token records the span of the pattern it was read from. Errors are
+// returned as a diagnostic.ErrorList; after most of them the lexer resumes
+// right after the offending text, so that a single call reports them all.
+func TokenizeWithFlags(inputPattern string, flags token.Flags) ([]token.Token, error) {
+	if errs := checkUTF8(inputPattern); len(errs) > 0 {
+		return nil, errs
+	}
+
+	tokens := make([]token.Token, 0, len(inputPattern)+1)
+	if flags != 0 {
+		tokens = append(tokens, &token.SetFlags{On: flags})
+	}
+	var errs diagnostic.ErrorList
+
+	// extended tracks the 'x' flag, which changes how the pattern itself is
+	// read. Like every inline flag it is restored at the end of a group.
+	extended := flags.Has(token.FlagExtended)
+	var outerExtended []bool
 
 	for inputIndex := 0; inputIndex < len(inputPattern); inputIndex++ {
-		currentCharacter := inputPattern[inputIndex]
+		currentCharacter, size := utf8.DecodeRuneInString(inputPattern[inputIndex:])
+
+		if extended {
+			if isPatternWhitespace(currentCharacter) {
+				continue
+			}
+			if currentCharacter == '#' {
+				for inputIndex < len(inputPattern) && inputPattern[inputIndex] != '\n' {
+					inputIndex++
+				}
+				continue
+			}
+		}
+
+		// Each case below sets length to the number of bytes the token
+		// spans, or err when that text is malformed.
+		start := inputIndex
+		length := size
 		var newToken token.Token
+		var err *diagnostic.PatternError
 
 		switch currentCharacter {
 		case '\\':
-			if inputIndex+1 >= len(inputPattern) {
-				return nil, fmt.Errorf("dangling backslash")
+			if start+1 >= len(inputPattern) {
+				err = diagnostic.Errorf(diagnostic.CodeDanglingBackslash, 0, 1, "dangling backslash")
+				break
 			}
-			nextCharacter := inputPattern[inputIndex+1]
+			length = 2
+			nextCharacter := inputPattern[start+1]
 			switch nextCharacter {
 			case 'd':
 				newToken = &token.Digit{}
 			case 'w':
 				newToken = &token.AlphaNumeric{}
+			case 's':
+				newToken = &token.Whitespace{}
+			case 'D':
+				newToken = &token.NonDigit{}
+			case 'W':
+				newToken = &token.NonAlphaNumeric{}
+			case 'S':
+				newToken = &token.NonWhitespace{}
+			case 'p', 'P':
+				var property predefinedclass.UnicodeProperty
+				property, length, err = lexUnicodeProperty(inputPattern[start:])
+				newToken = &token.UnicodeProperty{Property: property}
+			case 'A':
+				newToken = &token.StartAnchor{Absolute: true}
+			case 'z':
+				newToken = &token.EndAnchor{Absolute: true}
+			case 'Z':
+				newToken = &token.EndAnchor{Absolute: true, BeforeFinalNewline: true}
+			case 'b':
+				newToken = &token.WordBoundary{}
+			case 'B':
+				newToken = &token.NonWordBoundary{}
+			case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				newToken = &token.BackReference{GroupIndex: int(nextCharacter - '0')}
+			case 'k':
+				name, nameLength, ok := lexGroupName(inputPattern[start+2:])
+				if !ok {
+					err = diagnostic.Errorf(diagnostic.CodeInvalidBackReference, 0, 2, "invalid named back-reference")
+					break
+				}
+				newToken = &token.NamedBackReference{Name: name}
+				length += nameLength
+			case 'Q':
+				quoted, quotedLength := lexQuotedText(inputPattern[start+2:])
+				for offset, character := range quoted {
+					literal := &token.Literal{Literal: character}
+					literal.SetSpan(token.Span{Offset: start + 2 + offset, Length: utf8.RuneLen(character)})
+					tokens = append(tokens, literal)
+				}
+				length += quotedLength
+			case 'E':
+				// A \E without a matching \Q has nothing to end.
 			default:
-				newToken = &token.Literal{Literal: rune(nextCharacter)}
+				var character rune
+				character, length, err = lexCharacterEscape(inputPattern[start:])
+				newToken = &token.Literal{Literal: character}
 			}
-			inputIndex += 1
 		case '[':
-			distanceToClosing := strings.Index(inputPattern[inputIndex:], "]")
-			if distanceToClosing == -1 {
-				return nil, fmt.Errorf("unmatched character set opener [")
-			}
-
-			var setLiterals []rune
-			var characterClasses []predefinedclass.PredefinedClass
-			setCharacters := inputPattern[inputIndex+1 : inputIndex+distanceToClosing]
-			inputIndex += distanceToClosing
-
-			startingSetIndex := 0
-			negated := len(setCharacters) > 0 && setCharacters[0] == '^'
-			if negated {
-				startingSetIndex = 1
-			}
-
-			for setIndex := startingSetIndex; setIndex < len(setCharacters); setIndex++ {
-				currentGroupCharacter := setCharacters[setIndex]
-
-				if currentGroupCharacter == '\\' {
-					if setIndex+1 >= len(setCharacters) {
-						return nil, fmt.Errorf("dangling backslash inside character set")
-					}
-					nextCharacter := setCharacters[setIndex+1]
-					switch nextCharacter {
-					case 'd':
-						characterClasses = append(characterClasses, predefinedclass.ClassDigit)
-					case 'w':
-						characterClasses = append(characterClasses, predefinedclass.ClassAlphanumeric)
-					default:
-						setLiterals = append(setLiterals, rune(nextCharacter))
-					}
-					setIndex += 1
-				} else {
-					setLiterals = append(setLiterals, rune(setCharacters[setIndex]))
-				}
+			characterSet, setLength, setErrs := lexCharacterSet(inputPattern[start:])
+			for _, setErr := range setErrs {
+				setErr.Offset += start
+				errs = append(errs, setErr)
 			}
-
-			newToken = &token.CharacterSet{
-				IsPositive:       !negated,
-				CharacterClasses: characterClasses,
-				Literals:         setLiterals,
+			if len(setErrs) == 0 {
+				newToken = characterSet
 			}
-
+			length = setLength
 		case '^':
 			newToken = &token.StartAnchor{}
 		case '$':
@@ -82,24 +137,611 @@ func Tokenize(inputPattern string) ([]token.Token, error) {
 		case '*':
 			newToken = &token.KleeneClosure{}
 		case '+':
+			if markQuantifier(tokens, true) {
+				extendSpan(tokens[len(tokens)-1], start+length)
+				break
+			}
 			newToken = &token.PositiveClosure{}
 		case '?':
+			if markQuantifier(tokens, false) {
+				extendSpan(tokens[len(tokens)-1], start+length)
+				break
+			}
 			newToken = &token.OptionalQuantifier{}
+		case '{':
+			var repetition *token.BoundedRepetition
+			repetition, length, err = lexBoundedRepetition(inputPattern[start:])
+			if repetition == nil {
+				newToken = &token.Literal{Literal: '{'}
+				length = 1
+			} else {
+				newToken = repetition
+			}
 		case '.':
 			newToken = &token.Wildcard{}
 		case '|':
 			newToken = &token.Alternation{}
 		case '(':
-			newToken = &token.GroupingOpener{}
+			newToken, length, err = lexGroupOpener(inputPattern[start:])
+
+			// A malformed opener is tracked as a plain group, so that the
+			// matching ')' still restores the mode.
+			switch t := newToken.(type) {
+			case *token.SetFlags:
+				extended = flagsOf(extended).Apply(t.On, t.Off).Has(token.FlagExtended)
+			case *token.FlagGroupOpener:
+				outerExtended = append(outerExtended, extended)
+				extended = flagsOf(extended).Apply(t.On, t.Off).Has(token.FlagExtended)
+			default:
+				outerExtended = append(outerExtended, extended)
+			}
 		case ')':
 			newToken = &token.GroupingCloser{}
+			if len(outerExtended) > 0 {
+				extended = outerExtended[len(outerExtended)-1]
+				outerExtended = outerExtended[:len(outerExtended)-1]
+			}
 		default:
 			newToken = &token.Literal{
-				Literal: rune(inputPattern[inputIndex]),
+				Literal: currentCharacter,
 			}
 		}
+
+		if err != nil {
+			err.Offset += start
+			errs = append(errs, err)
+			inputIndex = err.Offset + max(err.Length, 1) - 1
+			continue
+		}
+		inputIndex = start + length - 1
+		if newToken == nil {
+			continue
+		}
+		newToken.SetSpan(token.Span{Offset: start, Length: length})
 		tokens = append(tokens, newToken)
 	}
 
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return tokens, nil
 }
+
+// checkUTF8 returns an error for every invalid UTF-8 sequence in pattern.
+func checkUTF8(pattern string) diagnostic.ErrorList {
+	var errs diagnostic.ErrorList
+	for index := 0; index < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[index:])
+		if r == utf8.RuneError && size == 1 {
+			errs = append(errs, diagnostic.Errorf(diagnostic.CodeInvalidUTF8, index, 1,
+				"invalid UTF-8 in pattern at byte offset %d", index))
+		}
+		index += size
+	}
+	return errs
+}
+
+// extendSpan stretches the span of t to end at end, for modifiers that are
+// folded into the token before them.
+func extendSpan(t token.Token, end int) {
+	span := t.Span()
+	span.Length = end - span.Offset
+	t.SetSpan(span)
+}
+
+// runePrefix returns the first n characters of text, or all of it if it is
+// shorter, for use in error messages.
+func runePrefix(text string, n int) string {
+	for index := range text {
+		if n == 0 {
+			return text[:index]
+		}
+		n--
+	}
+	return text
+}
+
+func isPatternWhitespace(c rune) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	default:
+		return false
+	}
+}
+
+// flagsOf returns the flags corresponding to the extended mode state.
+func flagsOf(extended bool) token.Flags {
+	if extended {
+		return token.FlagExtended
+	}
+	return 0
+}
+
+// quantifierModifiers returns pointers to the Lazy and Possessive flags of
+// t, or nils when t is not a quantifier.
+func quantifierModifiers(t token.Token) (*bool, *bool) {
+	switch q := t.(type) {
+	case *token.KleeneClosure:
+		return &q.Lazy, &q.Possessive
+	case *token.PositiveClosure:
+		return &q.Lazy, &q.Possessive
+	case *token.OptionalQuantifier:
+		return &q.Lazy, &q.Possessive
+	case *token.BoundedRepetition:
+		return &q.Lazy, &q.Possessive
+	default:
+		return nil, nil
+	}
+}
+
+// markQuantifier applies a '?' (lazy) or '+' (possessive) modifier to the
+// quantifier at the end of tokens, reporting whether there was an
+// unmodified quantifier to apply it to. Otherwise the character is a
+// quantifier of its own.
+func markQuantifier(tokens []token.Token, possessive bool) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	lazy, isPossessive := quantifierModifiers(tokens[len(tokens)-1])
+	if lazy == nil || *lazy || *isPossessive {
+		return false
+	}
+	if possessive {
+		*isPossessive = true
+	} else {
+		*lazy = true
+	}
+	return true
+}
+
+// lexGroupOpener reads the opening parenthesis at the start of pattern,
+// including any (?...) group syntax that follows it, and returns the
+// corresponding token together with the number of bytes it spans.
+func lexGroupOpener(pattern string) (token.Token, int, *diagnostic.PatternError) {
+	if !strings.HasPrefix(pattern, "(?") {
+		return &token.GroupingOpener{}, 1, nil
+	}
+	if strings.HasPrefix(pattern, "(?:") {
+		return &token.NonCapturingGroupOpener{}, 3, nil
+	}
+	if strings.HasPrefix(pattern, "(?>") {
+		return &token.AtomicGroupOpener{}, 3, nil
+	}
+	switch {
+	case strings.HasPrefix(pattern, "(?="):
+		return &token.LookaroundOpener{}, 3, nil
+	case strings.HasPrefix(pattern, "(?!"):
+		return &token.LookaroundOpener{Negated: true}, 3, nil
+	case strings.HasPrefix(pattern, "(?<="):
+		return &token.LookaroundOpener{Behind: true}, 4, nil
+	case strings.HasPrefix(pattern, "(?<!"):
+		return &token.LookaroundOpener{Behind: true, Negated: true}, 4, nil
+	}
+
+	nameStart := 0
+	if strings.HasPrefix(pattern, "(?P<") {
+		nameStart = 3
+	} else if strings.HasPrefix(pattern, "(?<") {
+		nameStart = 2
+	}
+	if nameStart != 0 {
+		name, length, ok := lexGroupName(pattern[nameStart:])
+		if !ok {
+			return nil, 0, diagnostic.Errorf(diagnostic.CodeInvalidGroupName, 0, nameStart+1, "invalid capture group name")
+		}
+		return &token.NamedGroupOpener{Name: name}, nameStart + length, nil
+	}
+
+	if flagsToken, length, ok := lexFlags(pattern); ok {
+		return flagsToken, length, nil
+	}
+
+	prefix := groupSyntaxPrefix(pattern)
+	return nil, 0, diagnostic.Errorf(diagnostic.CodeInvalidGroupSyntax, 0, len(prefix), "invalid group syntax %s", prefix)
+}
+
+// lexFlags reads an inline modifier such as (?i), (?m-s) or (?i:, which
+// starts at the beginning of pattern.
+func lexFlags(pattern string) (token.Token, int, bool) {
+	var on, off token.Flags
+	turningOff := false
+	for index := 2; index < len(pattern); index++ {
+		switch character := pattern[index]; character {
+		case ')':
+			if index == 2 {
+				return nil, 0, false
+			}
+			return &token.SetFlags{On: on, Off: off}, index + 1, true
+		case ':':
+			return &token.FlagGroupOpener{On: on, Off: off}, index + 1, true
+		case '-':
+			if turningOff {
+				return nil, 0, false
+			}
+			turningOff = true
+		default:
+			flag, ok := token.FlagLetters[character]
+			if !ok {
+				return nil, 0, false
+			}
+			if turningOff {
+				off |= flag
+			} else {
+				on |= flag
+			}
+		}
+	}
+	return nil, 0, false
+}
+
+// lexGroupName reads a <name> at the start of pattern, where name starts
+// with a letter or underscore and continues with letters, digits or
+// underscores. It returns the name and the number of bytes spanned,
+// including the angle brackets.
+func lexGroupName(pattern string) (string, int, bool) {
+	if !strings.HasPrefix(pattern, "<") {
+		return "", 0, false
+	}
+	nameEnd := 1
+	for nameEnd < len(pattern) && isNameCharacter(pattern[nameEnd], nameEnd == 1) {
+		nameEnd++
+	}
+	if nameEnd == 1 || nameEnd >= len(pattern) || pattern[nameEnd] != '>' {
+		return "", 0, false
+	}
+	return pattern[1:nameEnd], nameEnd + 1, true
+}
+
+func isNameCharacter(c byte, first bool) bool {
+	return isLetter(c) || c == '_' || (!first && c >= '0' && c <= '9')
+}
+
+// groupSyntaxPrefix returns the part of pattern that identifies a (?...)
+// construct, for use in error messages.
+func groupSyntaxPrefix(pattern string) string {
+	return runePrefix(pattern, 3)
+}
+
+// lexBoundedRepetition reads a counted quantifier ({n}, {n,} or {n,m}) at
+// the start of pattern and returns it together with the number of bytes it
+// spans. A brace that does not open a well-formed quantifier yields a nil
+// token so that it can be treated as a literal.
+func lexBoundedRepetition(pattern string) (*token.BoundedRepetition, int, *diagnostic.PatternError) {
+	closing := strings.IndexByte(pattern, '}')
+	if closing == -1 {
+		return nil, 0, nil
+	}
+	body := pattern[1:closing]
+
+	minText, maxText, hasComma := strings.Cut(body, ",")
+	if !isDecimal(minText) || (hasComma && maxText != "" && !isDecimal(maxText)) {
+		return nil, 0, nil
+	}
+
+	minCount, err := strconv.Atoi(minText)
+	if err != nil {
+		return nil, 0, diagnostic.Errorf(diagnostic.CodeInvalidRepetition, 0, closing+1, "invalid repetition count %s", minText)
+	}
+	maxCount := minCount
+	if hasComma {
+		maxCount = -1
+		if maxText != "" {
+			maxCount, err = strconv.Atoi(maxText)
+			if err != nil {
+				return nil, 0, diagnostic.Errorf(diagnostic.CodeInvalidRepetition, 0, closing+1, "invalid repetition count %s", maxText)
+			}
+		}
+	}
+
+	return &token.BoundedRepetition{Min: minCount, Max: maxCount}, closing + 1, nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDecimal(text string) bool {
+	if text == "" {
+		return false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] < '0' || text[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// posixClasses maps the names accepted in [:name:] bracket items to their
+// predefined classes.
+var posixClasses = map[string]predefinedclass.PredefinedClass{
+	"alnum":  predefinedclass.ClassPosixAlnum,
+	"alpha":  predefinedclass.ClassPosixAlpha,
+	"blank":  predefinedclass.ClassPosixBlank,
+	"cntrl":  predefinedclass.ClassPosixCntrl,
+	"digit":  predefinedclass.ClassPosixDigit,
+	"graph":  predefinedclass.ClassPosixGraph,
+	"lower":  predefinedclass.ClassPosixLower,
+	"print":  predefinedclass.ClassPosixPrint,
+	"punct":  predefinedclass.ClassPosixPunct,
+	"space":  predefinedclass.ClassPosixSpace,
+	"upper":  predefinedclass.ClassPosixUpper,
+	"xdigit": predefinedclass.ClassPosixXdigit,
+}
+
+// setItem is a single element of a bracket expression: either one
+// character, a predefined class such as \d or [:alpha:], or a Unicode
+// property such as \p{L}.
+type setItem struct {
+	character  rune
+	class      predefinedclass.PredefinedClass
+	property   predefinedclass.UnicodeProperty
+	isClass    bool
+	isProperty bool
+}
+
+// lexCharacterSet reads a bracket expression at the start of pattern and
+// returns it together with the number of bytes it spans. A ']' right after
+// the opening '[' (or '[^') is a literal, as is a '-' at either edge of the
+// set; backslash escapes work everywhere inside the brackets. Malformed
+// items are reported and skipped, so that every error in the set is found.
+func lexCharacterSet(pattern string) (*token.CharacterSet, int, diagnostic.ErrorList) {
+	characterSet := &token.CharacterSet{IsPositive: true}
+	setIndex := 1
+	var errs diagnostic.ErrorList
+
+	// skip records err, found in the item starting at itemStart, and moves
+	// past the text it covers.
+	skip := func(err *diagnostic.PatternError, itemStart int) {
+		err.Offset += itemStart
+		errs = append(errs, err)
+		setIndex = err.Offset + max(err.Length, 1)
+	}
+
+	if setIndex < len(pattern) && pattern[setIndex] == '^' {
+		characterSet.IsPositive = false
+		setIndex++
+	}
+
+	first := true
+	for {
+		if setIndex >= len(pattern) {
+			errs = append(errs, diagnostic.Errorf(diagnostic.CodeUnmatchedBracket, 0, 1, "unmatched character set opener ["))
+			return nil, len(pattern), errs
+		}
+		if pattern[setIndex] == ']' && !first {
+			return characterSet, setIndex + 1, errs
+		}
+		first = false
+
+		lowStart := setIndex
+		low, length, err := lexSetItem(pattern[setIndex:])
+		if err != nil {
+			skip(err, lowStart)
+			continue
+		}
+		setIndex += length
+
+		isRange := !low.isClass &&
+			setIndex+1 < len(pattern) &&
+			pattern[setIndex] == '-' &&
+			pattern[setIndex+1] != ']'
+		if !isRange {
+			addSetItem(characterSet, low)
+			continue
+		}
+
+		highStart := setIndex + 1
+		high, length, err := lexSetItem(pattern[highStart:])
+		if err != nil {
+			skip(err, highStart)
+			continue
+		}
+		setIndex = highStart + length
+		if high.isClass {
+			errs = append(errs, diagnostic.Errorf(diagnostic.CodeInvalidRange, lowStart, setIndex-lowStart,
+				"invalid character set range %c-%s", low.character, pattern[highStart:setIndex]))
+			continue
+		}
+		if low.character > high.character {
+			errs = append(errs, diagnostic.Errorf(diagnostic.CodeInvalidRange, lowStart, setIndex-lowStart,
+				"invalid character set range %c-%c: range values reversed", low.character, high.character))
+			continue
+		}
+		characterSet.Ranges = append(characterSet.Ranges, [2]rune{low.character, high.character})
+	}
+}
+
+func lexSetItem(pattern string) (setItem, int, *diagnostic.PatternError) {
+	if strings.HasPrefix(pattern, "[:") {
+		nameEnd := 2
+		for nameEnd < len(pattern) && isLetter(pattern[nameEnd]) {
+			nameEnd++
+		}
+		if strings.HasPrefix(pattern[nameEnd:], ":]") {
+			name := pattern[2:nameEnd]
+			class, ok := posixClasses[name]
+			if !ok {
+				return setItem{}, 0, diagnostic.Errorf(diagnostic.CodeUnknownPosixClass, 0, nameEnd+2, "unknown POSIX class name [:%s:]", name)
+			}
+			return setItem{class: class, isClass: true}, nameEnd + 2, nil
+		}
+	}
+	if pattern[0] != '\\' {
+		character, size := utf8.DecodeRuneInString(pattern)
+		return setItem{character: character}, size, nil
+	}
+	if len(pattern) < 2 {
+		return setItem{}, 0, diagnostic.Errorf(diagnostic.CodeDanglingBackslash, 0, 1, "dangling backslash inside character set")
+	}
+	switch pattern[1] {
+	case 'p', 'P':
+		property, length, err := lexUnicodeProperty(pattern)
+		if err != nil {
+			return setItem{}, 0, err
+		}
+		return setItem{property: property, isClass: true, isProperty: true}, length, nil
+	case 'd':
+		return setItem{class: predefinedclass.ClassDigit, isClass: true}, 2, nil
+	case 'w':
+		return setItem{class: predefinedclass.ClassAlphanumeric, isClass: true}, 2, nil
+	case 's':
+		return setItem{class: predefinedclass.ClassWhitespace, isClass: true}, 2, nil
+	case 'D':
+		return setItem{class: predefinedclass.ClassNonDigit, isClass: true}, 2, nil
+	case 'W':
+		return setItem{class: predefinedclass.ClassNonAlphanumeric, isClass: true}, 2, nil
+	case 'S':
+		return setItem{class: predefinedclass.ClassNonWhitespace, isClass: true}, 2, nil
+	case 'b':
+		// Inside a set there is no boundary to match, so \b is a backspace.
+		return setItem{character: '\b'}, 2, nil
+	default:
+		character, length, err := lexCharacterEscape(pattern)
+		if err != nil {
+			return setItem{}, 0, err
+		}
+		return setItem{character: character}, length, nil
+	}
+}
+
+func addSetItem(characterSet *token.CharacterSet, item setItem) {
+	if item.isProperty {
+		characterSet.Properties = append(characterSet.Properties, item.property)
+	} else if item.isClass {
+		characterSet.CharacterClasses = append(characterSet.CharacterClasses, item.class)
+	} else {
+		characterSet.Literals = append(characterSet.Literals, item.character)
+	}
+}
+
+// lexUnicodeProperty reads a \p or \P class at the start of pattern, either
+// with a one-letter name (\pL) or with a braced one (\p{Greek}), and
+// returns it together with the number of bytes it spans.
+func lexUnicodeProperty(pattern string) (predefinedclass.UnicodeProperty, int, *diagnostic.PatternError) {
+	property := predefinedclass.UnicodeProperty{Negated: pattern[1] == 'P'}
+	length := 0
+	switch {
+	case len(pattern) > 2 && pattern[2] == '{':
+		nameEnd := strings.IndexByte(pattern, '}')
+		if nameEnd == -1 {
+			return property, 0, diagnostic.Errorf(diagnostic.CodeInvalidProperty, 0, len(pattern), "unterminated Unicode property %s", pattern)
+		}
+		property.Name = pattern[3:nameEnd]
+		length = nameEnd + 1
+	case len(pattern) > 2 && isLetter(pattern[2]):
+		property.Name = pattern[2:3]
+		length = 3
+	default:
+		return property, 0, diagnostic.Errorf(diagnostic.CodeInvalidProperty, 0, 2, "missing Unicode property name after %s", pattern[:2])
+	}
+	if _, ok := predefinedclass.PropertyTable(property.Name); !ok {
+		return property, 0, diagnostic.Errorf(diagnostic.CodeUnknownProperty, 0, length, "unknown Unicode property %s", property.Name)
+	}
+	return property, length, nil
+}
+
+// simpleEscapes maps the letters of single-character escapes such as \t to
+// the characters they stand for.
+var simpleEscapes = map[byte]rune{
+	'a': '\a',
+	'e': 0x1b,
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'v': '\v',
+}
+
+// lexCharacterEscape reads an escape sequence standing for a single
+// character at the start of pattern and returns that character together
+// with the number of bytes the sequence spans. Besides the escapes in
+// simpleEscapes it accepts \xHH, \x{H...}, \uHHHH, \0, \0o, \0oo,
+// \o{o...} and control escapes \cX. Escaping any other non-alphanumeric
+// character yields that character; any other letter or digit is an error,
+// so that no escape silently matches itself.
+func lexCharacterEscape(pattern string) (rune, int, *diagnostic.PatternError) {
+	escape := pattern[1]
+	if character, ok := simpleEscapes[escape]; ok {
+		return character, 2, nil
+	}
+
+	switch escape {
+	case 'x':
+		if strings.HasPrefix(pattern[2:], "{") {
+			return lexBracedCodePoint(pattern, 16)
+		}
+		return lexFixedCodePoint(pattern, 2)
+	case 'u':
+		return lexFixedCodePoint(pattern, 4)
+	case 'o':
+		if !strings.HasPrefix(pattern[2:], "{") {
+			return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidEscape, 0, 2, "invalid escape sequence %s", pattern[:2])
+		}
+		return lexBracedCodePoint(pattern, 8)
+	case '0':
+		length := 2
+		for length < 4 && length < len(pattern) && pattern[length] >= '0' && pattern[length] <= '7' {
+			length++
+		}
+		value, _ := strconv.ParseUint(pattern[1:length], 8, 32)
+		return rune(value), length, nil
+	case 'c':
+		if len(pattern) < 3 || !isLetter(pattern[2]) {
+			sequence := runePrefix(pattern, 3)
+			return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidEscape, 0, len(sequence), "invalid control escape %s", sequence)
+		}
+		return rune(pattern[2] & 0x1f), 3, nil
+	}
+
+	if isLetter(escape) || (escape >= '0' && escape <= '9') {
+		return 0, 0, diagnostic.Errorf(diagnostic.CodeUnknownEscape, 0, 2, "unknown escape sequence %s", pattern[:2])
+	}
+	character, size := utf8.DecodeRuneInString(pattern[1:])
+	return character, 1 + size, nil
+}
+
+// lexFixedCodePoint reads an escape such as \xHH or \uHHHH, made of a
+// letter and exactly digits hexadecimal digits.
+func lexFixedCodePoint(pattern string, digits int) (rune, int, *diagnostic.PatternError) {
+	length := 2 + digits
+	if len(pattern) < length {
+		return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidEscape, 0, len(pattern), "invalid escape sequence %s", pattern)
+	}
+	return parseCodePoint(pattern[:length], pattern[2:length], 16)
+}
+
+// lexBracedCodePoint reads an escape such as \x{HHHH} or \o{ooo}, whose
+// digits in the given base are enclosed in braces.
+func lexBracedCodePoint(pattern string, base int) (rune, int, *diagnostic.PatternError) {
+	closer := strings.IndexByte(pattern, '}')
+	if closer == -1 {
+		return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidEscape, 0, len(pattern), "unterminated escape sequence %s", pattern)
+	}
+	return parseCodePoint(pattern[:closer+1], pattern[3:closer], base)
+}
+
+// parseCodePoint parses the digits of the escape sequence and checks that
+// they name a valid code point.
+func parseCodePoint(sequence, digits string, base int) (rune, int, *diagnostic.PatternError) {
+	value, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || strings.ContainsAny(digits, "+-_") {
+		return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidEscape, 0, len(sequence), "invalid escape sequence %s", sequence)
+	}
+	if value > unicode.MaxRune || (value >= 0xd800 && value <= 0xdfff) {
+		return 0, 0, diagnostic.Errorf(diagnostic.CodeInvalidCodePoint, 0, len(sequence), "escape sequence %s is not a valid code point", sequence)
+	}
+	return rune(value), len(sequence), nil
+}
+
+// lexQuotedText reads the text after a \Q up to the next \E, or to the end
+// of the pattern if there is none. It returns the text and the number of
+// bytes read, including the \E.
+func lexQuotedText(pattern string) (string, int) {
+	end := strings.Index(pattern, `\E`)
+	if end == -1 {
+		return pattern, len(pattern)
+	}
+	return pattern[:end], end + 2
+}
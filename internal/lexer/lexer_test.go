@@ -1,14 +1,24 @@
 package lexer
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
 
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
 	"github.com/mmarchesotti/build-your-own-grep/internal/predefinedclass"
 	"github.com/mmarchesotti/build-your-own-grep/internal/token"
 )
 
+// clearSpans resets the spans of tokens, so that tests about what was
+// tokenized need not spell out where each token came from.
+func clearSpans(tokens []token.Token) {
+	for _, t := range tokens {
+		t.SetSpan(token.Span{})
+	}
+}
+
 func TestTokenize(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -27,14 +37,14 @@ func TestTokenize(t *testing.T) {
 		},
 		{
 			name:  "all metacharacters",
-			input: `*+?|^$.`,
+			input: `*^+$?|.`,
 			expected: []token.Token{
 				&token.KleeneClosure{},
+				&token.StartAnchor{},
 				&token.PositiveClosure{},
+				&token.EndAnchor{},
 				&token.OptionalQuantifier{},
 				&token.Alternation{},
-				&token.StartAnchor{},
-				&token.EndAnchor{},
 				&token.Wildcard{},
 			},
 		},
@@ -53,6 +63,330 @@ func TestTokenize(t *testing.T) {
 				&token.AlphaNumeric{},
 			},
 		},
+		{
+			name:  "whitespace and negated classes",
+			input: `\s\S\D\W`,
+			expected: []token.Token{
+				&token.Whitespace{},
+				&token.NonWhitespace{},
+				&token.NonDigit{},
+				&token.NonAlphaNumeric{},
+			},
+		},
+		{
+			name:  "non-capturing group",
+			input: `(?:a)`,
+			expected: []token.Token{
+				&token.NonCapturingGroupOpener{},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+			},
+		},
+		{
+			name:  "named groups and named back-reference",
+			input: `(?P<year>a)(?<day>b)\k<year>`,
+			expected: []token.Token{
+				&token.NamedGroupOpener{Name: "year"},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+				&token.NamedGroupOpener{Name: "day"},
+				&token.Literal{Literal: 'b'},
+				&token.GroupingCloser{},
+				&token.NamedBackReference{Name: "year"},
+			},
+		},
+		{
+			name:  "lookarounds",
+			input: `(?=a)(?!b)(?<=c)(?<!d)`,
+			expected: []token.Token{
+				&token.LookaroundOpener{},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+				&token.LookaroundOpener{Negated: true},
+				&token.Literal{Literal: 'b'},
+				&token.GroupingCloser{},
+				&token.LookaroundOpener{Behind: true},
+				&token.Literal{Literal: 'c'},
+				&token.GroupingCloser{},
+				&token.LookaroundOpener{Behind: true, Negated: true},
+				&token.Literal{Literal: 'd'},
+				&token.GroupingCloser{},
+			},
+		},
+		{
+			name:  "inline flags",
+			input: `(?i)(?m-s)(?i:a)`,
+			expected: []token.Token{
+				&token.SetFlags{On: token.FlagCaseInsensitive},
+				&token.SetFlags{On: token.FlagMultiline, Off: token.FlagDotAll},
+				&token.FlagGroupOpener{On: token.FlagCaseInsensitive},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+			},
+		},
+		{
+			name:  "verbose mode skips whitespace and comments",
+			input: "(?x) a b # comment\n c\\ d",
+			expected: []token.Token{
+				&token.SetFlags{On: token.FlagExtended},
+				&token.Literal{Literal: 'a'},
+				&token.Literal{Literal: 'b'},
+				&token.Literal{Literal: 'c'},
+				&token.Literal{Literal: ' '},
+				&token.Literal{Literal: 'd'},
+			},
+		},
+		{
+			name:  "verbose mode leaves bracket expressions alone",
+			input: "(?x)[ #]",
+			expected: []token.Token{
+				&token.SetFlags{On: token.FlagExtended},
+				&token.CharacterSet{IsPositive: true, Literals: []rune{' ', '#'}},
+			},
+		},
+		{
+			name:  "scoped verbose mode",
+			input: "(?x: a )( b) c",
+			expected: []token.Token{
+				&token.FlagGroupOpener{On: token.FlagExtended},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+				&token.GroupingOpener{},
+				&token.Literal{Literal: ' '},
+				&token.Literal{Literal: 'b'},
+				&token.GroupingCloser{},
+				&token.Literal{Literal: ' '},
+				&token.Literal{Literal: 'c'},
+			},
+		},
+		{
+			name:  "verbose mode turned off",
+			input: "(?x)(a (?-x) b) c",
+			expected: []token.Token{
+				&token.SetFlags{On: token.FlagExtended},
+				&token.GroupingOpener{},
+				&token.Literal{Literal: 'a'},
+				&token.SetFlags{Off: token.FlagExtended},
+				&token.Literal{Literal: ' '},
+				&token.Literal{Literal: 'b'},
+				&token.GroupingCloser{},
+				&token.Literal{Literal: 'c'},
+			},
+		},
+		{
+			name:  "unicode properties",
+			input: `\p{Lu}\PL[\p{Greek}\P{Nd}a]`,
+			expected: []token.Token{
+				&token.UnicodeProperty{Property: predefinedclass.UnicodeProperty{Name: "Lu"}},
+				&token.UnicodeProperty{Property: predefinedclass.UnicodeProperty{Name: "L", Negated: true}},
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{'a'},
+					Properties: []predefinedclass.UnicodeProperty{
+						{Name: "Greek"},
+						{Name: "Nd", Negated: true},
+					},
+				},
+			},
+		},
+		{
+			name:     "unknown unicode property",
+			input:    `\p{Klingon}`,
+			expected: nil,
+			err:      fmt.Errorf("unknown Unicode property Klingon"),
+		},
+		{
+			name:     "unterminated unicode property",
+			input:    `[\p{L]`,
+			expected: nil,
+			err:      fmt.Errorf("unterminated Unicode property \\p{L]; unmatched character set opener ["),
+		},
+		{
+			name:  "character escapes",
+			input: `\t\n\r\f\v\a\e\x41\x{1F600}\u00e9\0\012\o{101}\cJ\.`,
+			expected: []token.Token{
+				&token.Literal{Literal: '\t'},
+				&token.Literal{Literal: '\n'},
+				&token.Literal{Literal: '\r'},
+				&token.Literal{Literal: '\f'},
+				&token.Literal{Literal: '\v'},
+				&token.Literal{Literal: '\a'},
+				&token.Literal{Literal: 0x1b},
+				&token.Literal{Literal: 'A'},
+				&token.Literal{Literal: 0x1f600},
+				&token.Literal{Literal: 'é'},
+				&token.Literal{Literal: 0},
+				&token.Literal{Literal: '\n'},
+				&token.Literal{Literal: 'A'},
+				&token.Literal{Literal: '\n'},
+				&token.Literal{Literal: '.'},
+			},
+		},
+		{
+			name:  "escapes in character set",
+			input: `[\t\x20-\x7e\b]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{'\t', '\b'},
+					Ranges:     [][2]rune{{' ', '~'}},
+				},
+			},
+		},
+		{
+			name:  "quoted text",
+			input: `\Qa.*\E+\Q(`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'a'},
+				&token.Literal{Literal: '.'},
+				&token.Literal{Literal: '*'},
+				&token.PositiveClosure{},
+				&token.Literal{Literal: '('},
+			},
+		},
+		{
+			name:     "unknown escape",
+			input:    `\y`,
+			expected: nil,
+			err:      fmt.Errorf("unknown escape sequence \\y"),
+		},
+		{
+			name:     "unknown escape in character set",
+			input:    `[\q]`,
+			expected: nil,
+			err:      fmt.Errorf("unknown escape sequence \\q"),
+		},
+		{
+			name:     "short hexadecimal escape",
+			input:    `\x4`,
+			expected: nil,
+			err:      fmt.Errorf("invalid escape sequence \\x4"),
+		},
+		{
+			name:     "invalid hexadecimal digits",
+			input:    `\x{12G}`,
+			expected: nil,
+			err:      fmt.Errorf("invalid escape sequence \\x{12G}"),
+		},
+		{
+			name:     "code point out of range",
+			input:    `\x{110000}`,
+			expected: nil,
+			err:      fmt.Errorf("escape sequence \\x{110000} is not a valid code point"),
+		},
+		{
+			name:     "invalid control escape",
+			input:    `\c1`,
+			expected: nil,
+			err:      fmt.Errorf("invalid control escape \\c1"),
+		},
+		{
+			name:  "multibyte literals",
+			input: `café+`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'c'},
+				&token.Literal{Literal: 'a'},
+				&token.Literal{Literal: 'f'},
+				&token.Literal{Literal: 'é'},
+				&token.PositiveClosure{},
+			},
+		},
+		{
+			name:  "multibyte set members and ranges",
+			input: `[äöü α-ω][^日本]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{'ä', 'ö', 'ü', ' '},
+					Ranges:     [][2]rune{{'α', 'ω'}},
+				},
+				&token.CharacterSet{
+					IsPositive: false,
+					Literals:   []rune{'日', '本'},
+				},
+			},
+		},
+		{
+			name:  "escaped multibyte characters",
+			input: `\€[\ß]`,
+			expected: []token.Token{
+				&token.Literal{Literal: '€'},
+				&token.CharacterSet{IsPositive: true, Literals: []rune{'ß'}},
+			},
+		},
+		{
+			name:     "reversed multibyte range",
+			input:    `[ω-α]`,
+			expected: nil,
+			err:      fmt.Errorf("invalid character set range ω-α: range values reversed"),
+		},
+		{
+			name:     "invalid UTF-8",
+			input:    "ab\xffc",
+			expected: nil,
+			err:      fmt.Errorf("invalid UTF-8 in pattern at byte offset 2"),
+		},
+		{
+			name:     "invalid UTF-8 in character set",
+			input:    "[é\xc3]",
+			expected: nil,
+			err:      fmt.Errorf("invalid UTF-8 in pattern at byte offset 3"),
+		},
+		{
+			name:     "multibyte character in invalid group syntax",
+			input:    `(?éa)`,
+			expected: nil,
+			err:      fmt.Errorf("invalid group syntax (?é"),
+		},
+		{
+			name:  "absolute anchors",
+			input: `\A\z\Z`,
+			expected: []token.Token{
+				&token.StartAnchor{Absolute: true},
+				&token.EndAnchor{Absolute: true},
+				&token.EndAnchor{Absolute: true, BeforeFinalNewline: true},
+			},
+		},
+		{
+			name:     "unknown inline flag",
+			input:    `(?q)`,
+			expected: nil,
+			err:      fmt.Errorf("invalid group syntax (?q"),
+		},
+		{
+			name:     "invalid group name",
+			input:    `(?P<1st>a)`,
+			expected: nil,
+			err:      fmt.Errorf("invalid capture group name"),
+		},
+		{
+			name:     "unknown group syntax",
+			input:    `(?@a)`,
+			expected: nil,
+			err:      fmt.Errorf("invalid group syntax (?@"),
+		},
+		{
+			name:  "word boundaries",
+			input: `\bcat\B`,
+			expected: []token.Token{
+				&token.WordBoundary{},
+				&token.Literal{Literal: 'c'},
+				&token.Literal{Literal: 'a'},
+				&token.Literal{Literal: 't'},
+				&token.NonWordBoundary{},
+			},
+		},
+		{
+			name:  "back-references",
+			input: `(a)\1\9`,
+			expected: []token.Token{
+				&token.GroupingOpener{},
+				&token.Literal{Literal: 'a'},
+				&token.GroupingCloser{},
+				&token.BackReference{GroupIndex: 1},
+				&token.BackReference{GroupIndex: 9},
+			},
+		},
 		{
 			name:  "simple character set",
 			input: "[abc]",
@@ -87,14 +421,97 @@ func TestTokenize(t *testing.T) {
 			},
 		},
 		{
-			name:  "empty character set",
-			input: `[]`,
+			name:  "character set with negated classes",
+			input: `[\S\d\s]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					CharacterClasses: []predefinedclass.PredefinedClass{
+						predefinedclass.ClassNonWhitespace,
+						predefinedclass.ClassDigit,
+						predefinedclass.ClassWhitespace,
+					},
+				},
+			},
+		},
+		{
+			name:  "POSIX classes in character set",
+			input: `[[:alpha:][:xdigit:]_]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{'_'},
+					CharacterClasses: []predefinedclass.PredefinedClass{
+						predefinedclass.ClassPosixAlpha,
+						predefinedclass.ClassPosixXdigit,
+					},
+				},
+			},
+		},
+		{
+			name:     "unknown POSIX class",
+			input:    `[[:letters:]]`,
+			expected: nil,
+			err:      fmt.Errorf("unknown POSIX class name [:letters:]"),
+		},
+		{
+			name:     "closing bracket in first position is a literal",
+			input:    `[]`,
+			expected: nil,
+			err:      fmt.Errorf("unmatched character set opener ["),
+		},
+		{
+			name:  "literal closing bracket",
+			input: `[]a]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{']', 'a'},
+				},
+			},
+		},
+		{
+			name:  "escaped characters in character set",
+			input: `[\]\-\^]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{']', '-', '^'},
+				},
+			},
+		},
+		{
+			name:  "character set ranges",
+			input: `[a-z0-9_]`,
+			expected: []token.Token{
+				&token.CharacterSet{
+					IsPositive: true,
+					Literals:   []rune{'_'},
+					Ranges:     [][2]rune{{'a', 'z'}, {'0', '9'}},
+				},
+			},
+		},
+		{
+			name:  "dash at the edges of a character set",
+			input: `[-a-c-][^-x]`,
 			expected: []token.Token{
 				&token.CharacterSet{
 					IsPositive: true,
+					Literals:   []rune{'-', '-'},
+					Ranges:     [][2]rune{{'a', 'c'}},
+				},
+				&token.CharacterSet{
+					IsPositive: false,
+					Literals:   []rune{'-', 'x'},
 				},
 			},
 		},
+		{
+			name:     "reversed character set range",
+			input:    `[z-a]`,
+			expected: nil,
+			err:      fmt.Errorf("invalid character set range z-a: range values reversed"),
+		},
 		{
 			name:  "literal and character set concatenation",
 			input: `a[bc]`,
@@ -120,6 +537,64 @@ func TestTokenize(t *testing.T) {
 				&token.Literal{Literal: 'd'},
 			},
 		},
+		{
+			name:  "bounded repetitions",
+			input: `a{3}b{2,}c{1,4}`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'a'},
+				&token.BoundedRepetition{Min: 3, Max: 3},
+				&token.Literal{Literal: 'b'},
+				&token.BoundedRepetition{Min: 2, Max: -1},
+				&token.Literal{Literal: 'c'},
+				&token.BoundedRepetition{Min: 1, Max: 4},
+			},
+		},
+		{
+			name:  "lazy quantifiers",
+			input: `a*?b+?c??d{2,3}?e???`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'a'},
+				&token.KleeneClosure{Lazy: true},
+				&token.Literal{Literal: 'b'},
+				&token.PositiveClosure{Lazy: true},
+				&token.Literal{Literal: 'c'},
+				&token.OptionalQuantifier{Lazy: true},
+				&token.Literal{Literal: 'd'},
+				&token.BoundedRepetition{Min: 2, Max: 3, Lazy: true},
+				&token.Literal{Literal: 'e'},
+				&token.OptionalQuantifier{Lazy: true},
+				&token.OptionalQuantifier{},
+			},
+		},
+		{
+			name:  "possessive quantifiers and atomic group",
+			input: `a*+b++c?+(?>d){2}+e*?+`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'a'},
+				&token.KleeneClosure{Possessive: true},
+				&token.Literal{Literal: 'b'},
+				&token.PositiveClosure{Possessive: true},
+				&token.Literal{Literal: 'c'},
+				&token.OptionalQuantifier{Possessive: true},
+				&token.AtomicGroupOpener{},
+				&token.Literal{Literal: 'd'},
+				&token.GroupingCloser{},
+				&token.BoundedRepetition{Min: 2, Max: 2, Possessive: true},
+				&token.Literal{Literal: 'e'},
+				&token.KleeneClosure{Lazy: true},
+				&token.PositiveClosure{},
+			},
+		},
+		{
+			name:  "brace that is not a quantifier",
+			input: `a{x}`,
+			expected: []token.Token{
+				&token.Literal{Literal: 'a'},
+				&token.Literal{Literal: '{'},
+				&token.Literal{Literal: 'x'},
+				&token.Literal{Literal: '}'},
+			},
+		},
 		{
 			name:     "unmatched opening bracket",
 			input:    `[abc`,
@@ -147,6 +622,7 @@ func TestTokenize(t *testing.T) {
 				return
 			}
 
+			clearSpans(actual)
 			if !reflect.DeepEqual(actual, tt.expected) {
 				t.Errorf("Tokenize() for input '%s' failed", tt.input)
 				t.Errorf("got:  %#v", actual)
@@ -155,3 +631,91 @@ func TestTokenize(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizeWithFlags(t *testing.T) {
+	actual, err := TokenizeWithFlags("a b # comment", token.FlagExtended)
+	if err != nil {
+		t.Fatalf("TokenizeWithFlags() returned an unexpected error: %v", err)
+	}
+	clearSpans(actual)
+
+	expected := []token.Token{
+		&token.SetFlags{On: token.FlagExtended},
+		&token.Literal{Literal: 'a'},
+		&token.Literal{Literal: 'b'},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("got:  %#v", actual)
+		t.Errorf("want: %#v", expected)
+	}
+}
+
+func TestTokenizeSpans(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Span
+	}{
+		{
+			input:    `ab`,
+			expected: []token.Span{{Offset: 0, Length: 1}, {Offset: 1, Length: 1}},
+		},
+		{
+			input:    `é\d[xy]`,
+			expected: []token.Span{{Offset: 0, Length: 2}, {Offset: 2, Length: 2}, {Offset: 4, Length: 4}},
+		},
+		{
+			input:    `(?:a{2,3}?)`,
+			expected: []token.Span{{Offset: 0, Length: 3}, {Offset: 3, Length: 1}, {Offset: 4, Length: 6}, {Offset: 10, Length: 1}},
+		},
+		{
+			input:    `\Qa.\E*`,
+			expected: []token.Span{{Offset: 2, Length: 1}, {Offset: 3, Length: 1}, {Offset: 6, Length: 1}},
+		},
+		{
+			input:    "(?x) a # comment\n b",
+			expected: []token.Span{{Offset: 0, Length: 4}, {Offset: 5, Length: 1}, {Offset: 18, Length: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokens, err := Tokenize(tt.input)
+			if err != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", err)
+			}
+			actual := make([]token.Span, len(tokens))
+			for i, tok := range tokens {
+				actual[i] = tok.Span()
+			}
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("got:  %v", actual)
+				t.Errorf("want: %v", tt.expected)
+			}
+		})
+	}
+}
+
+func TestTokenizeReportsAllErrors(t *testing.T) {
+	_, err := Tokenize(`a\y(?@b)[z-a\q]\p{Klingon}`)
+
+	var errs diagnostic.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("Tokenize() returned %v, want a diagnostic.ErrorList", err)
+	}
+
+	expected := diagnostic.ErrorList{
+		{Offset: 1, Length: 2, Code: diagnostic.CodeUnknownEscape, Message: `unknown escape sequence \y`},
+		{Offset: 3, Length: 3, Code: diagnostic.CodeInvalidGroupSyntax, Message: `invalid group syntax (?@`},
+		{Offset: 9, Length: 3, Code: diagnostic.CodeInvalidRange, Message: `invalid character set range z-a: range values reversed`},
+		{Offset: 12, Length: 2, Code: diagnostic.CodeUnknownEscape, Message: `unknown escape sequence \q`},
+		{Offset: 15, Length: 11, Code: diagnostic.CodeUnknownProperty, Message: `unknown Unicode property Klingon`},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		for _, e := range errs {
+			t.Errorf("got:  %+v", *e)
+		}
+		for _, e := range expected {
+			t.Errorf("want: %+v", *e)
+		}
+	}
+}
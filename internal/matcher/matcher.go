@@ -1,6 +1,9 @@
 package matcher
 
-import "fmt"
+import (
+	"fmt"
+	"unicode"
+)
 
 func isDigit(r rune) bool {
 	return r >= '0' && r <= '9'
@@ -22,8 +25,37 @@ func isAlphaNumeric(r rune) bool {
 	return isAlpha(r) || isDigit(r) || r == '_'
 }
 
+func isWhitespace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	default:
+		return false
+	}
+}
+
+// The Unicode variants below back \d, \w and \s in Unicode mode: a
+// decimal digit of any script, a letter, mark, digit or connector
+// punctuation, and any character Unicode treats as space.
+func isUnicodeDigit(r rune) bool {
+	return unicode.IsDigit(r)
+}
+
+func isUnicodeAlphaNumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || unicode.Is(unicode.Pc, r)
+}
+
+func isUnicodeWhitespace(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+// isGraph reports whether r is a visible ASCII character.
+func isGraph(r rune) bool {
+	return r > ' ' && r < 0x7f
+}
+
 func match(r rune, rng [2]rune) (bool, error) {
-	if rng[1] > rng[0] {
+	if rng[0] > rng[1] {
 		return false, fmt.Errorf("range values reversed")
 	}
 	return r >= rng[0] && r <= rng[1], nil
@@ -46,6 +78,29 @@ func (l *LiteralMatcher) Match(r rune) (bool, error) {
 	return r == l.Literal, nil
 }
 
+// CaseFoldedLiteralMatcher matches Literal in any of its cases.
+type CaseFoldedLiteralMatcher struct {
+	Literal rune
+}
+
+func (l *CaseFoldedLiteralMatcher) Match(r rune) (bool, error) {
+	return EqualFold(r, l.Literal), nil
+}
+
+// EqualFold reports whether a and b are the same character under simple
+// Unicode case folding.
+func EqualFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for folded := unicode.SimpleFold(a); folded != a; folded = unicode.SimpleFold(folded) {
+		if folded == b {
+			return true
+		}
+	}
+	return false
+}
+
 type CharacterSetMatcher struct {
 	IsPositive               bool
 	Literals                 []rune
@@ -54,9 +109,19 @@ type CharacterSetMatcher struct {
 }
 
 func (p *CharacterSetMatcher) Match(r rune) (bool, error) {
+	m, err := p.contains(r)
+	if err != nil {
+		return false, err
+	}
+	return m == p.IsPositive, nil
+}
+
+// contains reports whether r is one of the set's members, ignoring whether
+// the set is negated.
+func (p *CharacterSetMatcher) contains(r rune) (bool, error) {
 	for _, literal := range p.Literals {
 		if r == literal {
-			return p.IsPositive, nil
+			return true, nil
 		}
 	}
 	for _, rng := range p.Ranges {
@@ -65,7 +130,7 @@ func (p *CharacterSetMatcher) Match(r rune) (bool, error) {
 			return false, err
 		}
 		if m {
-			return p.IsPositive, nil
+			return true, nil
 		}
 	}
 	for _, characterClass := range p.CharacterClassesMatchers {
@@ -74,30 +139,230 @@ func (p *CharacterSetMatcher) Match(r rune) (bool, error) {
 			return false, err
 		}
 		if m {
-			return p.IsPositive, nil
+			return true, nil
 		}
 	}
-	return !p.IsPositive, nil
+	return false, nil
 }
 
-type WildcardMatcher struct{}
+// CaseFoldedCharacterSetMatcher matches like Set, except that a character
+// is a member when any of its cases is.
+type CaseFoldedCharacterSetMatcher struct {
+	Set *CharacterSetMatcher
+}
+
+func (p *CaseFoldedCharacterSetMatcher) Match(r rune) (bool, error) {
+	folded := r
+	for {
+		m, err := p.Set.contains(folded)
+		if err != nil {
+			return false, err
+		}
+		if m {
+			return p.Set.IsPositive, nil
+		}
+		folded = unicode.SimpleFold(folded)
+		if folded == r {
+			return !p.Set.IsPositive, nil
+		}
+	}
+}
+
+// WildcardMatcher matches any character except a newline, unless
+// MatchNewline is set.
+type WildcardMatcher struct {
+	MatchNewline bool
+}
 
 func (w *WildcardMatcher) Match(r rune) (bool, error) {
-	return r != '\n', nil
+	return w.MatchNewline || r != '\n', nil
 }
 
-type DigitMatcher struct{}
+// The \d, \w and \s matchers and their negations use ASCII definitions
+// unless Unicode is set.
+type DigitMatcher struct {
+	Unicode bool
+}
 
 func (d *DigitMatcher) Match(r rune) (bool, error) {
+	if d.Unicode {
+		return isUnicodeDigit(r), nil
+	}
 	return isDigit(r), nil
 }
 
 func (d *DigitMatcher) isPredefinedClass() {}
 
-type AlphaNumericMatcher struct{}
+type AlphaNumericMatcher struct {
+	Unicode bool
+}
 
 func (a *AlphaNumericMatcher) Match(r rune) (bool, error) {
+	if a.Unicode {
+		return isUnicodeAlphaNumeric(r), nil
+	}
 	return isAlphaNumeric(r), nil
 }
 
 func (a *AlphaNumericMatcher) isPredefinedClass() {}
+
+type WhitespaceMatcher struct {
+	Unicode bool
+}
+
+func (w *WhitespaceMatcher) Match(r rune) (bool, error) {
+	if w.Unicode {
+		return isUnicodeWhitespace(r), nil
+	}
+	return isWhitespace(r), nil
+}
+
+func (w *WhitespaceMatcher) isPredefinedClass() {}
+
+type NonDigitMatcher struct {
+	Unicode bool
+}
+
+func (d *NonDigitMatcher) Match(r rune) (bool, error) {
+	if d.Unicode {
+		return !isUnicodeDigit(r), nil
+	}
+	return !isDigit(r), nil
+}
+
+func (d *NonDigitMatcher) isPredefinedClass() {}
+
+type NonAlphaNumericMatcher struct {
+	Unicode bool
+}
+
+func (a *NonAlphaNumericMatcher) Match(r rune) (bool, error) {
+	if a.Unicode {
+		return !isUnicodeAlphaNumeric(r), nil
+	}
+	return !isAlphaNumeric(r), nil
+}
+
+func (a *NonAlphaNumericMatcher) isPredefinedClass() {}
+
+type NonWhitespaceMatcher struct {
+	Unicode bool
+}
+
+func (w *NonWhitespaceMatcher) Match(r rune) (bool, error) {
+	if w.Unicode {
+		return !isUnicodeWhitespace(r), nil
+	}
+	return !isWhitespace(r), nil
+}
+
+func (w *NonWhitespaceMatcher) isPredefinedClass() {}
+
+type PosixAlnumMatcher struct{}
+
+func (p *PosixAlnumMatcher) Match(r rune) (bool, error) {
+	return isAlpha(r) || isDigit(r), nil
+}
+
+func (p *PosixAlnumMatcher) isPredefinedClass() {}
+
+type PosixAlphaMatcher struct{}
+
+func (p *PosixAlphaMatcher) Match(r rune) (bool, error) {
+	return isAlpha(r), nil
+}
+
+func (p *PosixAlphaMatcher) isPredefinedClass() {}
+
+type PosixBlankMatcher struct{}
+
+func (p *PosixBlankMatcher) Match(r rune) (bool, error) {
+	return r == ' ' || r == '\t', nil
+}
+
+func (p *PosixBlankMatcher) isPredefinedClass() {}
+
+type PosixCntrlMatcher struct{}
+
+func (p *PosixCntrlMatcher) Match(r rune) (bool, error) {
+	return r < 0x20 || r == 0x7f, nil
+}
+
+func (p *PosixCntrlMatcher) isPredefinedClass() {}
+
+type PosixDigitMatcher struct{}
+
+func (p *PosixDigitMatcher) Match(r rune) (bool, error) {
+	return isDigit(r), nil
+}
+
+func (p *PosixDigitMatcher) isPredefinedClass() {}
+
+type PosixGraphMatcher struct{}
+
+func (p *PosixGraphMatcher) Match(r rune) (bool, error) {
+	return isGraph(r), nil
+}
+
+func (p *PosixGraphMatcher) isPredefinedClass() {}
+
+type PosixLowerMatcher struct{}
+
+func (p *PosixLowerMatcher) Match(r rune) (bool, error) {
+	return isLower(r), nil
+}
+
+func (p *PosixLowerMatcher) isPredefinedClass() {}
+
+type PosixPrintMatcher struct{}
+
+func (p *PosixPrintMatcher) Match(r rune) (bool, error) {
+	return isGraph(r) || r == ' ', nil
+}
+
+func (p *PosixPrintMatcher) isPredefinedClass() {}
+
+type PosixPunctMatcher struct{}
+
+func (p *PosixPunctMatcher) Match(r rune) (bool, error) {
+	return isGraph(r) && !isAlpha(r) && !isDigit(r), nil
+}
+
+func (p *PosixPunctMatcher) isPredefinedClass() {}
+
+type PosixSpaceMatcher struct{}
+
+func (p *PosixSpaceMatcher) Match(r rune) (bool, error) {
+	return isWhitespace(r), nil
+}
+
+func (p *PosixSpaceMatcher) isPredefinedClass() {}
+
+type PosixUpperMatcher struct{}
+
+func (p *PosixUpperMatcher) Match(r rune) (bool, error) {
+	return isUpper(r), nil
+}
+
+func (p *PosixUpperMatcher) isPredefinedClass() {}
+
+type PosixXdigitMatcher struct{}
+
+func (p *PosixXdigitMatcher) Match(r rune) (bool, error) {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'), nil
+}
+
+func (p *PosixXdigitMatcher) isPredefinedClass() {}
+
+// UnicodePropertyMatcher matches characters in Table, or outside it when
+// Negated is set.
+type UnicodePropertyMatcher struct {
+	Table   *unicode.RangeTable
+	Negated bool
+}
+
+func (u *UnicodePropertyMatcher) Match(r rune) (bool, error) {
+	return unicode.Is(u.Table, r) != u.Negated, nil
+}
+
+func (u *UnicodePropertyMatcher) isPredefinedClass() {}
@@ -1,6 +1,10 @@
 package nfa
 
-import "github.com/mmarchesotti/build-your-own-grep/internal/matcher"
+import (
+	"unicode/utf8"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/matcher"
+)
 
 type Fragment struct {
 	Start State
@@ -27,6 +31,12 @@ type SplitState struct {
 	Branch2 State
 }
 
+// EpsilonState moves to Out without consuming input.
+type EpsilonState struct {
+	BaseState
+	Out State
+}
+
 type MatcherState struct {
 	BaseState
 	Out     State
@@ -45,14 +55,130 @@ type CaptureEndState struct {
 	GroupIndex int
 }
 
+// BackReferenceState matches the text captured by GroupIndex. Only the
+// backtracking engine can evaluate it.
+type BackReferenceState struct {
+	BaseState
+	Out             State
+	GroupIndex      int
+	CaseInsensitive bool
+}
+
+// AtomicGroupState runs the sub-automaton at Start, which ends in its own
+// AcceptingState, and continues at Out from the first match it finds. The
+// alternatives inside the group are never revisited once it has matched.
+type AtomicGroupState struct {
+	BaseState
+	Start State
+	Out   State
+}
+
+// LookaroundState runs the sub-automaton at Start, which ends in its own
+// AcceptingState, and continues at Out without consuming input if it
+// matches (or, when Negated, if it does not). A lookahead runs the
+// sub-automaton from the current position. A lookbehind runs it from each
+// position between MinLength and MaxLength runes before the current one and
+// requires it to end exactly at the current position.
+type LookaroundState struct {
+	BaseState
+	Start     State
+	Out       State
+	Behind    bool
+	Negated   bool
+	MinLength int
+	MaxLength int
+}
+
+// Starts returns the line indices from which the sub-automaton of s must
+// be run when evaluating it at index.
+func (s *LookaroundState) Starts(line []byte, index int) []int {
+	if !s.Behind {
+		return []int{index}
+	}
+	var starts []int
+	start := index
+	for length := 0; length <= s.MaxLength; length++ {
+		if length >= s.MinLength {
+			starts = append(starts, start)
+		}
+		if start == 0 {
+			break
+		}
+		_, size := utf8.DecodeLastRune(line[:start])
+		start -= size
+	}
+	return starts
+}
+
+// StartAnchorState matches, without consuming input, at the start of the
+// line, or also right after a newline when Multiline is set.
 type StartAnchorState struct {
 	BaseState
-	Out State
+	Out       State
+	Multiline bool
 }
 
+func (s *StartAnchorState) Matches(line []byte, index int) bool {
+	return index == 0 || (s.Multiline && line[index-1] == '\n')
+}
+
+// EndAnchorState matches, without consuming input, at the end of the line,
+// or also right before a newline when Multiline is set. With
+// BeforeFinalNewline it also matches before a newline that ends the line.
 type EndAnchorState struct {
 	BaseState
-	Out State
+	Out                State
+	Multiline          bool
+	BeforeFinalNewline bool
+}
+
+func (s *EndAnchorState) Matches(line []byte, index int) bool {
+	if index == len(line) {
+		return true
+	}
+	if line[index] != '\n' {
+		return false
+	}
+	return s.Multiline || (s.BeforeFinalNewline && index == len(line)-1)
+}
+
+// WordBoundaryState matches, without consuming input, where a word
+// character is on exactly one side of the current position. Word
+// characters are judged as \w judges them, with Unicode selecting the
+// Unicode definition.
+type WordBoundaryState struct {
+	BaseState
+	Out     State
+	Unicode bool
+}
+
+// NonWordBoundaryState matches, without consuming input, wherever
+// WordBoundaryState does not.
+type NonWordBoundaryState struct {
+	BaseState
+	Out     State
+	Unicode bool
+}
+
+// AtWordBoundary reports whether index in line lies between a word
+// character and a non-word character, treating the start and end of the
+// line as non-word characters. The runes on either side are decoded in
+// full, so positions inside multibyte UTF-8 input are judged by the
+// characters they separate rather than by individual bytes. Unicode
+// selects the Unicode definition of a word character.
+func AtWordBoundary(line []byte, index int, unicode bool) bool {
+	word := &matcher.AlphaNumericMatcher{Unicode: unicode}
+	before := false
+	if index > 0 {
+		r, _ := utf8.DecodeLastRune(line[:index])
+		before, _ = word.Match(r)
+	}
+	after := false
+	if index < len(line) {
+		r, _ := utf8.DecodeRune(line[index:])
+		after, _ = word.Match(r)
+	}
+	return before != after
 }
 
 type AcceptingState struct {
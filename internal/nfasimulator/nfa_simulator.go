@@ -1,7 +1,7 @@
 package nfasimulator
 
 import (
-	"fmt"
+	"iter"
 	"unicode/utf8"
 
 	"github.com/mmarchesotti/build-your-own-grep/internal/nfa"
@@ -18,8 +18,15 @@ type thread struct {
 	captures  []Capture
 }
 
-func (t *thread) key() string {
-	return fmt.Sprintf("%p-%d", t.state, t.lineIndex)
+// visit identifies a state reached at a line index. Every path through it
+// has the same future, so it only ever needs to be explored once.
+type visit struct {
+	state     nfa.State
+	lineIndex int
+}
+
+func (t *thread) key() visit {
+	return visit{state: t.state, lineIndex: t.lineIndex}
 }
 
 type task struct {
@@ -28,49 +35,133 @@ type task struct {
 	undoLog  []undoEntry
 }
 
+// MatchResult holds the captures of a single match, indexed by group
+// number, along with the table mapping group names to those numbers.
+// When several patterns are searched for at once, Pattern is the index of
+// the one that matched.
+type MatchResult struct {
+	Captures   []Capture
+	GroupNames map[string]int
+	Pattern    int
+}
+
+// Named returns the capture of the group called name.
+func (r MatchResult) Named(name string) (Capture, bool) {
+	groupIndex, ok := r.GroupNames[name]
+	if !ok {
+		return Capture{}, false
+	}
+	return r.Captures[groupIndex], true
+}
+
 type undoEntry struct {
 	captureIndex int
 	isStart      bool
 	oldValue     int
 }
 
-func Simulate(line []byte, fragment nfa.Fragment, captureCount int) (<-chan Capture, error) {
-	out := make(chan []Capture)
-
-	go func() {
-		defer close(out)
+// Simulate returns the successive matches of fragment in line, in the
+// order described by Matches.
+//
+// The states explored by a search that found no match cannot lead to one
+// from a later start either, so they are only forgotten once a match is
+// found. Until then each (state, lineIndex) pair is explored at most once
+// over all start positions, which keeps a line without a match linear in
+// its length.
+func Simulate(line []byte, fragment nfa.Fragment, captureCount int, groupNames map[string]int) (iter.Seq[MatchResult], error) {
+	return func(yield func(MatchResult) bool) {
+		visited := make(map[visit]bool)
+		results := Matches(line, groupNames, func(startIndex int) ([]Capture, bool) {
+			captures, found := findMatchAt(fragment.Start, line, startIndex, captureCount, visited)
+			if found {
+				clear(visited)
+			}
+			return captures, found
+		})
+		for result := range results {
+			if !yield(result) {
+				return
+			}
+		}
+	}, nil
+}
 
+// Matches returns the successive matches in line found by matchAt, which
+// returns the captures of the match starting exactly at startIndex, if
+// there is one. Each match is only searched for when the sequence is
+// iterated that far, so a caller that stops early leaves no work behind.
+//
+// The search starts at index 0 and moves forward one rune at a time until
+// a match is found. After a match it resumes where the match ended, so
+// matches never overlap. Since an empty match ends where it starts, the
+// search moves one rune past it instead, so that it always progresses.
+// An empty match that starts right where the previous match ended is not
+// reported: the previous match already stopped there, so it would add
+// nothing but an empty match abutting it, as a* would after the aa of aab.
+func Matches(line []byte, groupNames map[string]int, matchAt func(startIndex int) ([]Capture, bool)) iter.Seq[MatchResult] {
+	return func(yield func(MatchResult) bool) {
+		previousEnd := -1
 		searchIndex := 0
 		for searchIndex <= len(line) {
-			captures, found := findMatchAt(fragment.Start, line, searchIndex, captureCount)
-
-			if found {
-				out <- MatchResult{Captures: captures}
+			captures, found := matchAt(searchIndex)
+			if !found || (captures[0].End == searchIndex && searchIndex == previousEnd) {
+				searchIndex += runeWidth(line, searchIndex)
+				continue
+			}
 
-				endOfMatch := captures[1]
-				if endOfMatch == searchIndex {
-					searchIndex++
-				} else {
-					searchIndex = endOfMatch
-				}
+			if !yield(MatchResult{Captures: captures, GroupNames: groupNames}) {
+				return
+			}
+			previousEnd = captures[0].End
+			if previousEnd == searchIndex {
+				searchIndex += runeWidth(line, searchIndex)
 			} else {
-				searchIndex++
+				searchIndex = previousEnd
 			}
 		}
-	}()
-
-	return out, nil
-
+	}
 }
 
-func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount int) (chan <- []Capture, bool) {
-	stack := []task{}
+// runeWidth returns the number of bytes of the rune at index in line, or 1
+// at the end of the line so that a search there still moves past it.
+func runeWidth(line []byte, index int) int {
+	if index >= len(line) {
+		return 1
+	}
+	_, size := utf8.DecodeRune(line[index:])
+	return size
+}
 
+// findMatchAt explores the NFA depth first from startIndex, always trying
+// Branch1 of a split before Branch2, and stops at the first accepting state
+// it reaches. That state therefore belongs to the highest-priority path:
+// greedy quantifiers yield their longest match and lazy ones their
+// shortest. A (state, lineIndex) pair that was already explored cannot
+// succeed on a lower-priority path either, so it is skipped; visited holds
+// those pairs and is added to by the search.
+func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount int, visited map[visit]bool) ([]Capture, bool) {
 	initialCaptures := make([]Capture, captureCount)
 	for i := range initialCaptures {
 		initialCaptures[i] = Capture{Start: -1, End: -1}
 	}
 
+	_, captures, found := search(startState, line, startIndex, initialCaptures, -1, visited)
+	return captures, found
+}
+
+// search runs the depth-first exploration described on findMatchAt from
+// startState, starting with a copy of captures. It returns the line index
+// at which the first accepting state was reached and the captures of that
+// path. It is also used to run the sub-automata of atomic groups and
+// lookarounds, which end in their own accepting state. When requiredEnd is
+// not -1, accepting states reached at any other line index are ignored.
+// The pairs explored are recorded in visited.
+func search(startState nfa.State, line []byte, startIndex int, captures []Capture, requiredEnd int, visited map[visit]bool) (int, []Capture, bool) {
+	stack := []task{}
+
+	initialCaptures := make([]Capture, len(captures))
+	copy(initialCaptures, captures)
+
 	initialThread := thread{
 		state:     startState,
 		lineIndex: startIndex,
@@ -82,8 +173,6 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 		undoLog:  nil,
 	})
 
-	visited := make(map[string]bool)
-
 	for len(stack) > 0 {
 		currentTask := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
@@ -108,7 +197,25 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 		currentState := currentTask.thread.state
 		switch st := currentState.(type) {
 		case *nfa.AcceptingState:
-			out <- currentTask.thread.captures, true
+			if requiredEnd != -1 && currentTask.thread.lineIndex != requiredEnd {
+				continue
+			}
+			captures := make([]Capture, len(currentTask.thread.captures))
+			copy(captures, currentTask.thread.captures)
+			return currentTask.thread.lineIndex, captures, true
+		case *nfa.AtomicGroupState:
+			end, captures, found := search(st.Start, line, currentTask.thread.lineIndex, currentTask.thread.captures, -1, make(map[visit]bool))
+			if found {
+				nextThread := thread{
+					state:     st.Out,
+					lineIndex: end,
+					captures:  captures,
+				}
+				stack = append(stack, task{
+					isRevert: false,
+					thread:   nextThread,
+				})
+			}
 		case *nfa.MatcherState:
 			if currentTask.thread.lineIndex < len(line) {
 				r, size := utf8.DecodeRune(line[currentTask.thread.lineIndex:])
@@ -125,6 +232,15 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 					})
 				}
 			}
+		case *nfa.EpsilonState:
+			nextThread := thread{
+				state:     st.Out,
+				lineIndex: currentTask.thread.lineIndex,
+				captures:  currentTask.thread.captures}
+			stack = append(stack, task{
+				isRevert: false,
+				thread:   nextThread,
+			})
 		case *nfa.SplitState:
 			thread1 := thread{
 				state:     st.Branch1,
@@ -185,8 +301,34 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 				isRevert: false,
 				thread:   nextThread,
 			})
+		case *nfa.LookaroundState:
+			requiredEnd := -1
+			if st.Behind {
+				requiredEnd = currentTask.thread.lineIndex
+			}
+			matched := false
+			captures := currentTask.thread.captures
+			for _, start := range st.Starts(line, currentTask.thread.lineIndex) {
+				_, lookaroundCaptures, found := search(st.Start, line, start, currentTask.thread.captures, requiredEnd, make(map[visit]bool))
+				if found {
+					matched = true
+					captures = lookaroundCaptures
+					break
+				}
+			}
+			if matched != st.Negated {
+				nextThread := thread{
+					state:     st.Out,
+					lineIndex: currentTask.thread.lineIndex,
+					captures:  captures,
+				}
+				stack = append(stack, task{
+					isRevert: false,
+					thread:   nextThread,
+				})
+			}
 		case *nfa.StartAnchorState:
-			if currentTask.thread.lineIndex == 0 {
+			if st.Matches(line, currentTask.thread.lineIndex) {
 				nextThread := thread{
 					state:     st.Out,
 					lineIndex: currentTask.thread.lineIndex,
@@ -197,7 +339,29 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 				})
 			}
 		case *nfa.EndAnchorState:
-			if currentTask.thread.lineIndex == len(line) {
+			if st.Matches(line, currentTask.thread.lineIndex) {
+				nextThread := thread{
+					state:     st.Out,
+					lineIndex: currentTask.thread.lineIndex,
+					captures:  currentTask.thread.captures}
+				stack = append(stack, task{
+					isRevert: false,
+					thread:   nextThread,
+				})
+			}
+		case *nfa.WordBoundaryState:
+			if nfa.AtWordBoundary(line, currentTask.thread.lineIndex, st.Unicode) {
+				nextThread := thread{
+					state:     st.Out,
+					lineIndex: currentTask.thread.lineIndex,
+					captures:  currentTask.thread.captures}
+				stack = append(stack, task{
+					isRevert: false,
+					thread:   nextThread,
+				})
+			}
+		case *nfa.NonWordBoundaryState:
+			if !nfa.AtWordBoundary(line, currentTask.thread.lineIndex, st.Unicode) {
 				nextThread := thread{
 					state:     st.Out,
 					lineIndex: currentTask.thread.lineIndex,
@@ -210,5 +374,5 @@ func findMatchAt(startState nfa.State, line []byte, startIndex int, captureCount
 		}
 	}
 
-	return nil, false
+	return 0, nil, false
 }
@@ -1,26 +1,74 @@
 package parser
 
 import (
-	"fmt"
+	"sort"
 
 	"github.com/mmarchesotti/build-your-own-grep/internal/ast"
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
 	"github.com/mmarchesotti/build-your-own-grep/internal/token"
 )
 
+// maxRepetitionCount bounds the counts accepted in {n,m} quantifiers, since
+// every repetition is expanded into its own copy of the sub-automaton.
+const maxRepetitionCount = 1000
+
+// maxExpandedSize bounds the size of a pattern once every {n,m} quantifier
+// has been expanded, as measured by validate. Each count is bounded on its
+// own, but nested repetitions multiply.
+const maxExpandedSize = 100_000
+
 type Parser struct {
-	tokens       []token.Token
-	position     int
-	captureIndex int
+	tokens              []token.Token
+	position            int
+	flags               token.Flags
+	captureIndex        int
+	groupNames          map[string]int
+	backReferences      []*ast.BackReferenceNode
+	namedBackReferences map[*ast.BackReferenceNode]string
+	backReferenceSpans  map[*ast.BackReferenceNode]token.Span
+	// spans records where in the pattern each node was written, for the
+	// errors reported by validate. A quantifier's span is that of its
+	// operator and a group's that of its opener.
+	spans map[ast.ASTNode]token.Span
+	// errors collects the problems that do not stop parsing, so that
+	// they can all be reported together.
+	errors diagnostic.ErrorList
 }
 
 func NewParser(tokens []token.Token) *Parser {
 	return &Parser{
-		tokens:       tokens,
-		position:     0,
-		captureIndex: 0,
+		tokens:              tokens,
+		position:            0,
+		captureIndex:        0,
+		groupNames:          make(map[string]int),
+		namedBackReferences: make(map[*ast.BackReferenceNode]string),
+		backReferenceSpans:  make(map[*ast.BackReferenceNode]token.Span),
+		spans:               make(map[ast.ASTNode]token.Span),
 	}
 }
 
+// spanOf returns the span of t, or an empty span just past the last token
+// when t is nil because the input ended.
+func (p *Parser) spanOf(t token.Token) token.Span {
+	if t != nil {
+		return t.Span()
+	}
+	if len(p.tokens) == 0 {
+		return token.Span{}
+	}
+	return token.Span{Offset: p.tokens[len(p.tokens)-1].Span().End()}
+}
+
+// errorAt returns a PatternError located at span.
+func errorAt(span token.Span, code diagnostic.Code, format string, args ...any) *diagnostic.PatternError {
+	return diagnostic.Errorf(code, span.Offset, span.Length, format, args...)
+}
+
+// report records an error after which parsing can go on.
+func (p *Parser) report(span token.Span, code diagnostic.Code, format string, args ...any) {
+	p.errors = append(p.errors, errorAt(span, code, format, args...))
+}
+
 func (p *Parser) currentToken() token.Token {
 	if p.position >= len(p.tokens) {
 		return nil
@@ -52,13 +100,32 @@ func (p *Parser) parseExpression() (ast.ASTNode, error) {
 	return node, nil
 }
 
+// applyFlags consumes any inline (?flags) modifiers at the current
+// position and applies them to the flags in effect.
+func (p *Parser) applyFlags() {
+	for token.IsSetFlags(p.currentToken()) {
+		t := p.consumeToken().(*token.SetFlags)
+		p.flags = p.flags.Apply(t.On, t.Off)
+	}
+}
+
+// emptyNode returns an EmptyNode standing for an operand missing at the
+// current position.
+func (p *Parser) emptyNode() ast.ASTNode {
+	return &ast.EmptyNode{Offset: p.spanOf(p.currentToken()).Offset}
+}
+
 func (p *Parser) parseTerm() (ast.ASTNode, error) {
+	p.applyFlags()
+	if t := p.currentToken(); !token.CanConcatenate(t) && !token.IsUnaryOperator(t) {
+		return p.emptyNode(), nil
+	}
 	node, err := p.parseFactor()
 	if err != nil {
 		return nil, err
 	}
 
-	for token.CanConcatenate(p.currentToken()) {
+	for p.applyFlags(); token.CanConcatenate(p.currentToken()); p.applyFlags() {
 		rightNode, err := p.parseFactor()
 		if err != nil {
 			return nil, err
@@ -70,58 +137,154 @@ func (p *Parser) parseTerm() (ast.ASTNode, error) {
 }
 
 func (p *Parser) parseFactor() (ast.ASTNode, error) {
-	node, err := p.parseAtom()
-	if err != nil {
-		return nil, err
+	var node ast.ASTNode
+	if token.IsUnaryOperator(p.currentToken()) {
+		// Left for validate to report as having nothing to repeat.
+		node = p.emptyNode()
+	} else {
+		atom := p.currentToken()
+		var err error
+		node, err = p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if _, recorded := p.spans[node]; !recorded {
+			p.spans[node] = atom.Span()
+		}
 	}
 
 	for token.IsUnaryOperator(p.currentToken()) {
-		t := p.consumeToken()
-		switch t.(type) {
+		possessive := false
+		quantifier := p.consumeToken()
+		switch t := quantifier.(type) {
 		case *token.OptionalQuantifier:
+			possessive = t.Possessive
 			node = &ast.OptionalNode{
 				Child: node,
+				Lazy:  t.Lazy,
 			}
 		case *token.KleeneClosure:
+			possessive = t.Possessive
 			node = &ast.KleeneClosureNode{
 				Child: node,
+				Lazy:  t.Lazy,
 			}
 		case *token.PositiveClosure:
+			possessive = t.Possessive
 			node = &ast.PositiveClosureNode{
 				Child: node,
+				Lazy:  t.Lazy,
 			}
+		case *token.BoundedRepetition:
+			possessive = t.Possessive
+			node = &ast.BoundedRepetitionNode{
+				Child: node,
+				Min:   t.Min,
+				Max:   t.Max,
+				Lazy:  t.Lazy,
+			}
+		}
+		p.spans[node] = quantifier.Span()
+		if possessive {
+			node = &ast.AtomicGroupNode{Child: node}
+			p.spans[node] = quantifier.Span()
 		}
 	}
 
 	return node, nil
 }
 
+// parseGroup parses the expression enclosed by a group opener and its
+// closer, returning it without wrapping it in a capture. Inline flags set
+// inside the group do not outlive it.
+func (p *Parser) parseGroup() (ast.ASTNode, error) {
+	opener := p.consumeToken()
+
+	outerFlags := p.flags
+	defer func() { p.flags = outerFlags }()
+
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.IsGroupingCloser(p.currentToken()) {
+		return nil, errorAt(opener.Span(), diagnostic.CodeUnmatchedGroupOpener, "unmatched group opener")
+	}
+	p.consumeToken()
+
+	return node, nil
+}
+
+func (p *Parser) parseCaptureGroup(name string) (ast.ASTNode, error) {
+	opener := p.consumeToken()
+
+	p.captureIndex++
+	currentCaptureIndex := p.captureIndex
+
+	if name != "" {
+		if _, exists := p.groupNames[name]; exists {
+			p.report(opener.Span(), diagnostic.CodeDuplicateGroupName, "duplicate capture group name %s", name)
+		} else {
+			p.groupNames[name] = currentCaptureIndex
+		}
+	}
+
+	outerFlags := p.flags
+	defer func() { p.flags = outerFlags }()
+
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.IsGroupingCloser(p.currentToken()) {
+		return nil, errorAt(opener.Span(), diagnostic.CodeUnmatchedGroupOpener, "unmatched group opener")
+	}
+	p.consumeToken()
+
+	return &ast.CaptureGroupNode{
+		Child:      node,
+		GroupIndex: currentCaptureIndex,
+		Name:       name,
+	}, nil
+}
+
 func (p *Parser) parseAtom() (ast.ASTNode, error) {
 	switch t := p.currentToken().(type) {
 	case *token.GroupingOpener:
-		p.consumeToken()
-
-		p.captureIndex++
-		currentCaptureIndex := p.captureIndex
-
-		node, err := p.parseExpression()
+		return p.parseCaptureGroup("")
+	case *token.NamedGroupOpener:
+		return p.parseCaptureGroup(t.Name)
+	case *token.NonCapturingGroupOpener:
+		return p.parseGroup()
+	case *token.AtomicGroupOpener:
+		node, err := p.parseGroup()
 		if err != nil {
 			return nil, err
 		}
-
-		if !token.IsGroupingCloser(p.currentToken()) {
-			return nil, fmt.Errorf("unmatched group opener")
+		return &ast.AtomicGroupNode{Child: node}, nil
+	case *token.FlagGroupOpener:
+		outerFlags := p.flags
+		p.flags = p.flags.Apply(t.On, t.Off)
+		node, err := p.parseGroup()
+		p.flags = outerFlags
+		return node, err
+	case *token.LookaroundOpener:
+		node, err := p.parseGroup()
+		if err != nil {
+			return nil, err
 		}
-		p.consumeToken()
-
-		return &ast.CaptureGroupNode{
-			Child:      node,
-			GroupIndex: currentCaptureIndex,
+		return &ast.LookaroundNode{
+			Child:   node,
+			Behind:  t.Behind,
+			Negated: t.Negated,
 		}, nil
 	case *token.Literal:
 		p.consumeToken()
 		node := &ast.LiteralNode{
-			Literal: t.Literal,
+			Literal:         t.Literal,
+			CaseInsensitive: p.flags.Has(token.FlagCaseInsensitive),
 		}
 		return node, nil
 	case *token.CharacterSet:
@@ -131,40 +294,158 @@ func (p *Parser) parseAtom() (ast.ASTNode, error) {
 			Literals:         t.Literals,
 			Ranges:           t.Ranges,
 			CharacterClasses: t.CharacterClasses,
+			Properties:       t.Properties,
+			CaseInsensitive:  p.flags.Has(token.FlagCaseInsensitive),
+			Unicode:          p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.UnicodeProperty:
+		p.consumeToken()
+		node := &ast.UnicodePropertyNode{
+			Property: t.Property,
 		}
 		return node, nil
 	case *token.Wildcard:
 		p.consumeToken()
-		node := &ast.WildcardNode{}
+		node := &ast.WildcardNode{
+			MatchNewline: p.flags.Has(token.FlagDotAll),
+		}
 		return node, nil
 	case *token.Digit:
 		p.consumeToken()
-		node := &ast.DigitNode{}
+		node := &ast.DigitNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
 		return node, nil
 	case *token.AlphaNumeric:
 		p.consumeToken()
-		node := &ast.AlphaNumericNode{}
+		node := &ast.AlphaNumericNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.Whitespace:
+		p.consumeToken()
+		node := &ast.WhitespaceNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.NonDigit:
+		p.consumeToken()
+		node := &ast.NonDigitNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.NonAlphaNumeric:
+		p.consumeToken()
+		node := &ast.NonAlphaNumericNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.NonWhitespace:
+		p.consumeToken()
+		node := &ast.NonWhitespaceNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.BackReference:
+		p.consumeToken()
+		node := &ast.BackReferenceNode{
+			GroupIndex:      t.GroupIndex,
+			CaseInsensitive: p.flags.Has(token.FlagCaseInsensitive),
+		}
+		p.backReferences = append(p.backReferences, node)
+		p.backReferenceSpans[node] = t.Span()
+		return node, nil
+	case *token.NamedBackReference:
+		p.consumeToken()
+		node := &ast.BackReferenceNode{
+			CaseInsensitive: p.flags.Has(token.FlagCaseInsensitive),
+		}
+		p.backReferences = append(p.backReferences, node)
+		p.backReferenceSpans[node] = t.Span()
+		p.namedBackReferences[node] = t.Name
 		return node, nil
 	case *token.StartAnchor:
 		p.consumeToken()
-		node := &ast.StartAnchorNode{}
+		node := &ast.StartAnchorNode{
+			Multiline: !t.Absolute && p.flags.Has(token.FlagMultiline),
+		}
 		return node, nil
 	case *token.EndAnchor:
 		p.consumeToken()
-		node := &ast.EndAnchorNode{}
+		node := &ast.EndAnchorNode{
+			Multiline:          !t.Absolute && p.flags.Has(token.FlagMultiline),
+			BeforeFinalNewline: t.BeforeFinalNewline,
+		}
+		return node, nil
+	case *token.WordBoundary:
+		p.consumeToken()
+		node := &ast.WordBoundaryNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
+		return node, nil
+	case *token.NonWordBoundary:
+		p.consumeToken()
+		node := &ast.NonWordBoundaryNode{
+			Unicode: p.flags.Has(token.FlagUnicode),
+		}
 		return node, nil
 	case *token.GroupingCloser:
-		return nil, fmt.Errorf("unmatched group closer")
+		return nil, errorAt(t.Span(), diagnostic.CodeUnmatchedGroupCloser, "unmatched group closer")
+	case nil:
+		return nil, errorAt(p.spanOf(nil), diagnostic.CodeUnexpectedEndOfPattern, "unexpected end of pattern")
 	default:
-		return nil, fmt.Errorf("unexpected token: %T", t)
+		return nil, errorAt(t.Span(), diagnostic.CodeUnexpectedToken, "unexpected token: %T", t)
+	}
+}
+
+// resolveBackReferences fills in the group index of named back-references
+// and checks that every back-reference points at an existing group. It runs
+// after the whole pattern is parsed so that references may precede the
+// group they refer to.
+func (p *Parser) resolveBackReferences() {
+	for _, node := range p.backReferences {
+		span := p.backReferenceSpans[node]
+		if name, isNamed := p.namedBackReferences[node]; isNamed {
+			groupIndex, exists := p.groupNames[name]
+			if !exists {
+				p.report(span, diagnostic.CodeMissingGroup, "back-reference to non-existent group %s", name)
+				continue
+			}
+			node.GroupIndex = groupIndex
+		}
+		if node.GroupIndex > p.captureIndex {
+			p.report(span, diagnostic.CodeMissingGroup, "back-reference to non-existent group %d", node.GroupIndex)
+		}
 	}
 }
 
-func Parse(tokens []token.Token) (ast.ASTNode, int, error) {
+// Parse builds the AST for tokens. It also returns the number of capture
+// slots the pattern needs (including the implicit group 0 for the whole
+// match) and a table mapping capture group names to their indices.
+//
+// Errors are returned as a diagnostic.ErrorList. Problems that leave the
+// structure of the pattern intact, such as a bad repetition range or a
+// reference to a missing group, are all collected before Parse returns;
+// structural ones, such as an unmatched parenthesis, end parsing.
+func Parse(tokens []token.Token) (ast.ASTNode, int, map[string]int, error) {
 	parser := NewParser(tokens)
 	tree, err := parser.parseExpression()
 	if err != nil {
-		return nil, 0, err
+		parser.errors = append(parser.errors, err.(*diagnostic.PatternError))
+		return nil, 0, nil, parser.errors
+	}
+	if t := parser.currentToken(); t != nil {
+		parser.report(t.Span(), diagnostic.CodeUnmatchedGroupCloser, "unmatched group closer")
+		return nil, 0, nil, parser.errors
+	}
+	parser.resolveBackReferences()
+	parser.validate(tree)
+	if len(parser.errors) > 0 {
+		sort.SliceStable(parser.errors, func(i, j int) bool {
+			return parser.errors[i].Offset < parser.errors[j].Offset
+		})
+		return nil, 0, nil, parser.errors
 	}
-	return tree, parser.captureIndex + 1, nil
+	return tree, parser.captureIndex + 1, parser.groupNames, nil
 }
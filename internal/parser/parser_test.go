@@ -1,11 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/mmarchesotti/build-your-own-grep/internal/ast"
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
 	"github.com/mmarchesotti/build-your-own-grep/internal/lexer"
+	"github.com/mmarchesotti/build-your-own-grep/internal/predefinedclass"
 )
 
 // --- Test Helper Functions ---
@@ -19,10 +22,15 @@ func concat(left, right ast.ASTNode) ast.ASTNode {
 func star(child ast.ASTNode) ast.ASTNode { return &ast.KleeneClosureNode{Child: child} }
 func plus(child ast.ASTNode) ast.ASTNode { return &ast.PositiveClosureNode{Child: child} }
 func opt(child ast.ASTNode) ast.ASTNode  { return &ast.OptionalNode{Child: child} }
+func empty(offset int) ast.ASTNode       { return &ast.EmptyNode{Offset: offset} }
 func cs(pos bool, lits []rune) ast.ASTNode {
 	return &ast.CharacterSetNode{IsPositive: pos, Literals: lits}
 }
 
+func rep(child ast.ASTNode, min, max int) ast.ASTNode {
+	return &ast.BoundedRepetitionNode{Child: child, Min: min, Max: max}
+}
+
 func capg(index int, child ast.ASTNode) ast.ASTNode {
 	return &ast.CaptureGroupNode{GroupIndex: index, Child: child}
 }
@@ -66,6 +74,30 @@ func TestParse(t *testing.T) {
 			expected:      alt(concat(lit('a'), lit('b')), lit('c')),
 			expectedCount: 1,
 		},
+		{
+			name:          "empty pattern",
+			input:         "",
+			expected:      empty(0),
+			expectedCount: 1,
+		},
+		{
+			name:          "empty trailing alternative",
+			input:         "a|",
+			expected:      alt(lit('a'), empty(2)),
+			expectedCount: 1,
+		},
+		{
+			name:          "empty alternatives in a group",
+			input:         "(|b)",
+			expected:      capg(1, alt(empty(1), lit('b'))),
+			expectedCount: 2,
+		},
+		{
+			name:          "empty group",
+			input:         "a()",
+			expected:      concat(lit('a'), capg(1, empty(2))),
+			expectedCount: 2,
+		},
 		{
 			name:  "parentheses for scope",
 			input: "a(b|c)",
@@ -95,6 +127,88 @@ func TestParse(t *testing.T) {
 			expected:      concat(concat(star(lit('a')), plus(lit('b'))), opt(lit('c'))),
 			expectedCount: 1,
 		},
+		{
+			name:  "lazy quantifiers",
+			input: "a*?b+?c??",
+			expected: concat(
+				concat(
+					&ast.KleeneClosureNode{Child: lit('a'), Lazy: true},
+					&ast.PositiveClosureNode{Child: lit('b'), Lazy: true},
+				),
+				&ast.OptionalNode{Child: lit('c'), Lazy: true},
+			),
+			expectedCount: 1,
+		},
+		{
+			name:  "atomic group and possessive quantifier",
+			input: "(?>ab|a)c*+",
+			expected: concat(
+				&ast.AtomicGroupNode{Child: alt(concat(lit('a'), lit('b')), lit('a'))},
+				&ast.AtomicGroupNode{Child: star(lit('c'))},
+			),
+			expectedCount: 1,
+		},
+		{
+			name:  "lookarounds",
+			input: "a(?!b)(?<=a)",
+			expected: concat(
+				concat(
+					lit('a'),
+					&ast.LookaroundNode{Child: lit('b'), Negated: true},
+				),
+				&ast.LookaroundNode{Child: lit('a'), Behind: true},
+			),
+			expectedCount: 1,
+		},
+		{
+			name:  "inline flag applies until the end of its group",
+			input: "(a(?i)b)c",
+			expected: concat(
+				capg(1, concat(lit('a'), &ast.LiteralNode{Literal: 'b', CaseInsensitive: true})),
+				lit('c'),
+			),
+			expectedCount: 2,
+		},
+		{
+			name:  "scoped flags",
+			input: "(?s:.)(?m)^.",
+			expected: concat(
+				concat(
+					&ast.WildcardNode{MatchNewline: true},
+					&ast.StartAnchorNode{Multiline: true},
+				),
+				&ast.WildcardNode{},
+			),
+			expectedCount: 1,
+		},
+		{
+			name:  "flags turned off",
+			input: `(?im)a(?-i)b\A`,
+			expected: concat(
+				concat(
+					&ast.LiteralNode{Literal: 'a', CaseInsensitive: true},
+					lit('b'),
+				),
+				&ast.StartAnchorNode{},
+			),
+			expectedCount: 1,
+		},
+		{
+			name:  "unicode mode",
+			input: `\w(?u)\w\b[\d]`,
+			expected: concat(
+				concat(
+					concat(&ast.AlphaNumericNode{}, &ast.AlphaNumericNode{Unicode: true}),
+					&ast.WordBoundaryNode{Unicode: true},
+				),
+				&ast.CharacterSetNode{
+					IsPositive:       true,
+					CharacterClasses: []predefinedclass.PredefinedClass{predefinedclass.ClassDigit},
+					Unicode:          true,
+				},
+			),
+			expectedCount: 1,
+		},
 		{
 			name:          "character set",
 			input:         "[abc]",
@@ -113,6 +227,42 @@ func TestParse(t *testing.T) {
 			),
 			expectedCount: 2,
 		},
+		{
+			name:          "bounded repetitions",
+			input:         "a{2}b{1,}c{0,3}",
+			expected:      concat(concat(rep(lit('a'), 2, 2), rep(lit('b'), 1, -1)), rep(lit('c'), 0, 3)),
+			expectedCount: 1,
+		},
+		{
+			name:          "back-reference",
+			input:         `(a)\1`,
+			expected:      concat(capg(1, lit('a')), &ast.BackReferenceNode{GroupIndex: 1}),
+			expectedCount: 2,
+		},
+		{
+			name:          "non-capturing group",
+			input:         "(?:a|b)*c",
+			expected:      concat(star(alt(lit('a'), lit('b'))), lit('c')),
+			expectedCount: 1,
+		},
+		{
+			name:  "capture numbering skips non-capturing groups",
+			input: "(?:a)(b)",
+			expected: concat(
+				lit('a'),
+				capg(1, lit('b')),
+			),
+			expectedCount: 2,
+		},
+		{
+			name:  "named capture group and back-reference",
+			input: `(?P<word>a)\k<word>`,
+			expected: concat(
+				&ast.CaptureGroupNode{GroupIndex: 1, Name: "word", Child: lit('a')},
+				&ast.BackReferenceNode{GroupIndex: 1},
+			),
+			expectedCount: 2,
+		},
 		{
 			name:  "nested capture groups",
 			input: "a(b(c))d",
@@ -134,7 +284,7 @@ func TestParse(t *testing.T) {
 				t.Fatalf("Tokenize() returned an unexpected error: %v", tokenizeErr)
 			}
 
-			actual, actualCount, parseErr := Parse(tokens)
+			actual, actualCount, _, parseErr := Parse(tokens)
 			if parseErr != nil {
 				t.Fatalf("Parse() returned an unexpected error: %v", parseErr)
 			}
@@ -153,3 +303,227 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "reversed repetition range",
+			input: "a{3,1}",
+			err:   "invalid repetition range {3,1}",
+		},
+		{
+			name:  "repetition count above limit",
+			input: "a{1001}",
+			err:   "repetition count exceeds maximum of 1000",
+		},
+		{
+			name:  "nested repetitions above the size limit",
+			input: "(a{1000}){1000}",
+			err:   "repetition expands the pattern beyond the maximum size of 100000",
+		},
+		{
+			name:  "deeply nested repetitions",
+			input: "((a{1000}){1000}){10}",
+			err:   "repetition expands the pattern beyond the maximum size of 100000",
+		},
+		{
+			name:  "back-reference to missing group",
+			input: `(a)\2`,
+			err:   "back-reference to non-existent group 2",
+		},
+		{
+			name:  "duplicate group name",
+			input: `(?P<x>a)(?P<x>b)`,
+			err:   "duplicate capture group name x",
+		},
+		{
+			name:  "named back-reference to missing group",
+			input: `(?P<x>a)\k<y>`,
+			err:   "back-reference to non-existent group y",
+		},
+		{
+			name:  "unmatched group closer",
+			input: "a)b",
+			err:   "unmatched group closer",
+		},
+		{
+			name:  "unmatched group opener",
+			input: "(ab",
+			err:   "unmatched group opener",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, tokenizeErr := lexer.Tokenize(tt.input)
+			if tokenizeErr != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", tokenizeErr)
+			}
+
+			_, _, _, parseErr := Parse(tokens)
+			if parseErr == nil {
+				t.Fatalf("Parse() expected error '%s', but got nil", tt.err)
+			}
+			if parseErr.Error() != tt.err {
+				t.Fatalf("Parse() expected error '%s', but got '%v'", tt.err, parseErr)
+			}
+		})
+	}
+}
+
+func TestParseErrorLocations(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected diagnostic.ErrorList
+	}{
+		{
+			name:  "recoverable errors are all reported",
+			input: `(?P<x>a{3,1})(?P<x>b)\3\k<y>`,
+			expected: diagnostic.ErrorList{
+				{Offset: 7, Length: 5, Code: diagnostic.CodeInvalidRepetition, Message: "invalid repetition range {3,1}"},
+				{Offset: 13, Length: 6, Code: diagnostic.CodeDuplicateGroupName, Message: "duplicate capture group name x"},
+				{Offset: 21, Length: 2, Code: diagnostic.CodeMissingGroup, Message: "back-reference to non-existent group 3"},
+				{Offset: 23, Length: 5, Code: diagnostic.CodeMissingGroup, Message: "back-reference to non-existent group y"},
+			},
+		},
+		{
+			name:  "unmatched group opener points at the opener",
+			input: `a(?:b(c)`,
+			expected: diagnostic.ErrorList{
+				{Offset: 1, Length: 3, Code: diagnostic.CodeUnmatchedGroupOpener, Message: "unmatched group opener"},
+			},
+		},
+		{
+			name:  "unmatched group closer",
+			input: `ab)`,
+			expected: diagnostic.ErrorList{
+				{Offset: 2, Length: 1, Code: diagnostic.CodeUnmatchedGroupCloser, Message: "unmatched group closer"},
+			},
+		},
+		{
+			name:  "nothing to repeat",
+			input: `*a`,
+			expected: diagnostic.ErrorList{
+				{Offset: 0, Length: 1, Code: diagnostic.CodeNothingToRepeat, Message: "nothing to repeat"},
+			},
+		},
+		{
+			name:  "repetition too large is reported at the innermost repetition",
+			input: `((ab{100}){1000}){10}`,
+			expected: diagnostic.ErrorList{
+				{Offset: 10, Length: 6, Code: diagnostic.CodeRepetitionTooLarge, Message: "repetition expands the pattern beyond the maximum size of 100000"},
+			},
+		},
+		{
+			name:  "nested quantifier",
+			input: `a**`,
+			expected: diagnostic.ErrorList{
+				{Offset: 2, Length: 1, Code: diagnostic.CodeNestedQuantifier, Message: "nested quantifier"},
+			},
+		},
+		{
+			name:  "quantifier after a lazy quantifier",
+			input: `a+?{2}`,
+			expected: diagnostic.ErrorList{
+				{Offset: 3, Length: 3, Code: diagnostic.CodeNestedQuantifier, Message: "nested quantifier"},
+			},
+		},
+		{
+			name:  "quantified anchor",
+			input: `^*a`,
+			expected: diagnostic.ErrorList{
+				{Offset: 1, Length: 1, Code: diagnostic.CodeQuantifiedAssertion, Message: "cannot repeat a zero-width assertion"},
+			},
+		},
+		{
+			name:  "nothing to repeat after an empty alternative",
+			input: `a|+b`,
+			expected: diagnostic.ErrorList{
+				{Offset: 2, Length: 1, Code: diagnostic.CodeNothingToRepeat, Message: "nothing to repeat"},
+			},
+		},
+		{
+			name:  "reversed repetition range",
+			input: `a{3,1}`,
+			expected: diagnostic.ErrorList{
+				{Offset: 1, Length: 5, Code: diagnostic.CodeInvalidRepetition, Message: "invalid repetition range {3,1}"},
+			},
+		},
+		{
+			name:  "unbounded lookbehind",
+			input: `(?<=a+)b`,
+			expected: diagnostic.ErrorList{
+				{Offset: 0, Length: 4, Code: diagnostic.CodeUnboundedLookbehind, Message: "lookbehind requires a pattern of bounded length"},
+			},
+		},
+		{
+			name:  "errors are ordered by position",
+			input: `a{2,1}|*b`,
+			expected: diagnostic.ErrorList{
+				{Offset: 1, Length: 5, Code: diagnostic.CodeInvalidRepetition, Message: "invalid repetition range {2,1}"},
+				{Offset: 7, Length: 1, Code: diagnostic.CodeNothingToRepeat, Message: "nothing to repeat"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, tokenizeErr := lexer.Tokenize(tt.input)
+			if tokenizeErr != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", tokenizeErr)
+			}
+
+			_, _, _, parseErr := Parse(tokens)
+			var errs diagnostic.ErrorList
+			if !errors.As(parseErr, &errs) {
+				t.Fatalf("Parse() returned %v, want a diagnostic.ErrorList", parseErr)
+			}
+			if !reflect.DeepEqual(errs, tt.expected) {
+				for _, e := range errs {
+					t.Errorf("got:  %+v", *e)
+				}
+				for _, e := range tt.expected {
+					t.Errorf("want: %+v", *e)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAcceptsGroupedQuantifiers(t *testing.T) {
+	for _, input := range []string{`(a*)*`, `(?:a+)?`, `(?>a*)*`, `a*+`, `a{2}?`, `(?<=ab?)c`, `()*`, `(?:)+`, `(|a)?`, `(?:a{1000}){90}`} {
+		t.Run(input, func(t *testing.T) {
+			tokens, err := lexer.Tokenize(input)
+			if err != nil {
+				t.Fatalf("Tokenize() returned an unexpected error: %v", err)
+			}
+			if _, _, _, err := Parse(tokens); err != nil {
+				t.Fatalf("Parse() returned an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseGroupNames(t *testing.T) {
+	tokens, err := lexer.Tokenize(`(?P<date>\d+) (\w+) (?<level>\w+)`)
+	if err != nil {
+		t.Fatalf("Tokenize() returned an unexpected error: %v", err)
+	}
+
+	_, _, groupNames, err := Parse(tokens)
+	if err != nil {
+		t.Fatalf("Parse() returned an unexpected error: %v", err)
+	}
+
+	expected := map[string]int{"date": 1, "level": 3}
+	if !reflect.DeepEqual(groupNames, expected) {
+		t.Errorf("Parse() returned wrong group names")
+		t.Errorf("got:  %v", groupNames)
+		t.Errorf("want: %v", expected)
+	}
+}
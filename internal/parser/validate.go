@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"github.com/mmarchesotti/build-your-own-grep/internal/ast"
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
+)
+
+// validate walks the parsed tree and reports the constructs that the
+// grammar accepts but that have no sensible meaning, so that the NFA
+// builder only ever receives well-formed trees. They are:
+//
+//   - a quantifier with nothing before it to repeat, as in *a or a|+b.
+//     An empty group can be repeated, so ()* is accepted;
+//   - a quantifier written right after another one, as in a** or a+{2}.
+//     A group states the intent explicitly, so (?:a*)* is accepted;
+//   - a quantifier applied to an anchor or word boundary, as in ^*;
+//   - a counted repetition whose range is reversed or too large, or whose
+//     expansion makes the pattern larger than maxExpandedSize, as in
+//     (a{1000}){1000};
+//   - a lookbehind whose contents have no maximum length.
+//
+// A pattern that is well formed but can never match, such as a^b without
+// (?m), is accepted, as grep accepts it: it simply matches nothing.
+//
+// It returns the size of node once its counted repetitions are expanded,
+// counted in nodes, which is roughly the number of states built for it.
+func (p *Parser) validate(node ast.ASTNode) int {
+	switch n := node.(type) {
+	case *ast.AlternationNode:
+		return p.validate(n.Left) + p.validate(n.Right) + 1
+	case *ast.ConcatenationNode:
+		return p.validate(n.Left) + p.validate(n.Right)
+	case *ast.CaptureGroupNode:
+		return p.validate(n.Child) + 1
+	case *ast.AtomicGroupNode:
+		return p.validate(n.Child) + 1
+	case *ast.LookaroundNode:
+		if _, maxLength := ast.Length(n.Child); n.Behind && maxLength == -1 {
+			p.report(p.spans[n], diagnostic.CodeUnboundedLookbehind, "lookbehind requires a pattern of bounded length")
+		}
+		return p.validate(n.Child) + 1
+	case *ast.KleeneClosureNode:
+		return p.validateQuantifier(n, n.Child) + 1
+	case *ast.PositiveClosureNode:
+		return p.validateQuantifier(n, n.Child) + 1
+	case *ast.OptionalNode:
+		return p.validateQuantifier(n, n.Child) + 1
+	case *ast.BoundedRepetitionNode:
+		span := p.spans[n]
+		childSize := p.validateQuantifier(n, n.Child)
+		if n.Min > maxRepetitionCount || n.Max > maxRepetitionCount {
+			p.report(span, diagnostic.CodeRepetitionTooLarge, "repetition count exceeds maximum of %d", maxRepetitionCount)
+			return childSize
+		}
+		if n.Max != -1 && n.Min > n.Max {
+			p.report(span, diagnostic.CodeInvalidRepetition, "invalid repetition range {%d,%d}", n.Min, n.Max)
+			return childSize
+		}
+		// The child is copied once for each repetition up to the maximum,
+		// or once more than the minimum when there is no maximum.
+		copies := n.Max
+		if n.Max == -1 {
+			copies = n.Min + 1
+		}
+		size := childSize * copies
+		if size > maxExpandedSize {
+			// The error is reported once, at the innermost repetition
+			// that is too large, and not again for those around it.
+			p.report(span, diagnostic.CodeRepetitionTooLarge, "repetition expands the pattern beyond the maximum size of %d", maxExpandedSize)
+			return 1
+		}
+		return size
+	default:
+		return 1
+	}
+}
+
+// validateQuantifier checks what quantifier is applied to, then validates
+// child itself and returns its size.
+func (p *Parser) validateQuantifier(quantifier, child ast.ASTNode) int {
+	span := p.spans[quantifier]
+	switch child.(type) {
+	case *ast.EmptyNode:
+		// An operand that was written, such as (?:), has a span; one that
+		// is simply missing does not.
+		if _, written := p.spans[child]; !written {
+			p.report(span, diagnostic.CodeNothingToRepeat, "nothing to repeat")
+		}
+		return 1
+	case *ast.StartAnchorNode, *ast.EndAnchorNode, *ast.WordBoundaryNode, *ast.NonWordBoundaryNode:
+		p.report(span, diagnostic.CodeQuantifiedAssertion, "cannot repeat a zero-width assertion")
+		return 1
+	case *ast.KleeneClosureNode, *ast.PositiveClosureNode, *ast.OptionalNode,
+		*ast.BoundedRepetitionNode, *ast.AtomicGroupNode:
+		// Only a quantifier that ends right where this one starts was
+		// written without a group around it.
+		if p.spans[child].End() == span.Offset {
+			p.report(span, diagnostic.CodeNestedQuantifier, "nested quantifier")
+		}
+	}
+	return p.validate(child)
+}
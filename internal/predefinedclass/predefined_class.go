@@ -1,9 +1,51 @@
 package predefinedclass
 
+import "unicode"
+
 type PredefinedClass int
 
 const (
 	ClassDigit PredefinedClass = iota
 	ClassAlphanumeric
 	ClassWhitespace
+	ClassNonDigit
+	ClassNonAlphanumeric
+	ClassNonWhitespace
+	ClassPosixAlnum
+	ClassPosixAlpha
+	ClassPosixBlank
+	ClassPosixCntrl
+	ClassPosixDigit
+	ClassPosixGraph
+	ClassPosixLower
+	ClassPosixPrint
+	ClassPosixPunct
+	ClassPosixSpace
+	ClassPosixUpper
+	ClassPosixXdigit
 )
+
+// UnicodeProperty is a \p{Name} class, or \P{Name} when Negated is set.
+// Name is a general category such as L, Lu or Nd, a script such as Greek,
+// or Any.
+type UnicodeProperty struct {
+	Name    string
+	Negated bool
+}
+
+// anyTable covers every code point.
+var anyTable = &unicode.RangeTable{
+	R32: []unicode.Range32{{Lo: 0, Hi: unicode.MaxRune, Stride: 1}},
+}
+
+// PropertyTable returns the range table of the Unicode property called name.
+func PropertyTable(name string) (*unicode.RangeTable, bool) {
+	if name == "Any" {
+		return anyTable, true
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return table, true
+	}
+	table, ok := unicode.Scripts[name]
+	return table, ok
+}
@@ -5,23 +5,76 @@ import "github.com/mmarchesotti/build-your-own-grep/internal/predefinedclass"
 type TokenType string
 
 const (
-	INVALID             TokenType = "INVALID"
-	LITERAL             TokenType = "LITERAL"
-	DIGIT               TokenType = "DIGIT"
-	ALPHANUMERIC        TokenType = "ALPHANUMERIC"
-	CHARACTER_SET       TokenType = "CHARACTER_SET"
-	START_ANCHOR        TokenType = "START_ANCHOR"
-	END_ANCHOR          TokenType = "END_ANCHOR"
-	KLEENE_CLOSURE      TokenType = "KLEENE_CLOSURE"
-	POSITIVE_CLOSURE    TokenType = "POSITIVE_CLOSURE"
-	OPTIONAL_QUANTIFIER TokenType = "OPTIONAL_QUANTIFIER"
-	WILDCARD            TokenType = "WILDCARD"
-	ALTERNATION         TokenType = "ALTERNATION"
-	CONCATENATION       TokenType = "CONCATENATION"
-	GROUPING_OPENER     TokenType = "GROUPING_OPENER"
-	GROUPING_CLOSER     TokenType = "GROUPING_CLOSER"
+	INVALID              TokenType = "INVALID"
+	LITERAL              TokenType = "LITERAL"
+	DIGIT                TokenType = "DIGIT"
+	ALPHANUMERIC         TokenType = "ALPHANUMERIC"
+	WHITESPACE           TokenType = "WHITESPACE"
+	NON_DIGIT            TokenType = "NON_DIGIT"
+	NON_ALPHANUMERIC     TokenType = "NON_ALPHANUMERIC"
+	NON_WHITESPACE       TokenType = "NON_WHITESPACE"
+	CHARACTER_SET        TokenType = "CHARACTER_SET"
+	UNICODE_PROPERTY     TokenType = "UNICODE_PROPERTY"
+	START_ANCHOR         TokenType = "START_ANCHOR"
+	WORD_BOUNDARY        TokenType = "WORD_BOUNDARY"
+	NON_WORD_BOUNDARY    TokenType = "NON_WORD_BOUNDARY"
+	END_ANCHOR           TokenType = "END_ANCHOR"
+	KLEENE_CLOSURE       TokenType = "KLEENE_CLOSURE"
+	POSITIVE_CLOSURE     TokenType = "POSITIVE_CLOSURE"
+	OPTIONAL_QUANTIFIER  TokenType = "OPTIONAL_QUANTIFIER"
+	BOUNDED_REPETITION   TokenType = "BOUNDED_REPETITION"
+	WILDCARD             TokenType = "WILDCARD"
+	ALTERNATION          TokenType = "ALTERNATION"
+	CONCATENATION        TokenType = "CONCATENATION"
+	GROUPING_OPENER      TokenType = "GROUPING_OPENER"
+	GROUPING_CLOSER      TokenType = "GROUPING_CLOSER"
+	NON_CAPTURING_GROUP  TokenType = "NON_CAPTURING_GROUP"
+	NAMED_GROUP          TokenType = "NAMED_GROUP"
+	SET_FLAGS            TokenType = "SET_FLAGS"
+	FLAG_GROUP           TokenType = "FLAG_GROUP"
+	LOOKAROUND           TokenType = "LOOKAROUND"
+	ATOMIC_GROUP         TokenType = "ATOMIC_GROUP"
+	BACK_REFERENCE       TokenType = "BACK_REFERENCE"
+	NAMED_BACK_REFERENCE TokenType = "NAMED_BACK_REFERENCE"
 )
 
+// Flags are the matching modes that can be switched by inline modifiers.
+type Flags uint8
+
+const (
+	// FlagCaseInsensitive ('i') makes letters match regardless of case.
+	FlagCaseInsensitive Flags = 1 << iota
+	// FlagMultiline ('m') makes '^' and '$' also match at embedded newlines.
+	FlagMultiline
+	// FlagDotAll ('s') makes '.' match newlines too.
+	FlagDotAll
+	// FlagExtended ('x') makes the lexer ignore unescaped whitespace and
+	// '#' comments outside bracket expressions.
+	FlagExtended
+	// FlagUnicode ('u') makes \w, \d, \s and word boundaries use the
+	// Unicode definitions of letters, digits and spaces instead of ASCII.
+	FlagUnicode
+)
+
+// FlagLetters maps inline modifier letters to the flags they control.
+var FlagLetters = map[byte]Flags{
+	'i': FlagCaseInsensitive,
+	'm': FlagMultiline,
+	's': FlagDotAll,
+	'x': FlagExtended,
+	'u': FlagUnicode,
+}
+
+// Apply returns f with on turned on and off turned off.
+func (f Flags) Apply(on, off Flags) Flags {
+	return (f | on) &^ off
+}
+
+// Has reports whether every flag in other is set in f.
+func (f Flags) Has(other Flags) bool {
+	return f&other == other
+}
+
 // --- Helper Functions ---
 
 func IsAlternation(t Token) bool {
@@ -30,8 +83,13 @@ func IsAlternation(t Token) bool {
 }
 
 func IsGroupingOpener(t Token) bool {
-	_, ok := t.(*GroupingOpener)
-	return ok
+	switch t.(type) {
+	case *GroupingOpener, *NonCapturingGroupOpener, *NamedGroupOpener,
+		*AtomicGroupOpener, *LookaroundOpener, *FlagGroupOpener:
+		return true
+	default:
+		return false
+	}
 }
 
 func IsGroupingCloser(t Token) bool {
@@ -39,10 +97,20 @@ func IsGroupingCloser(t Token) bool {
 	return ok
 }
 
+func IsSetFlags(t Token) bool {
+	_, ok := t.(*SetFlags)
+	return ok
+}
+
 func CanConcatenate(t Token) bool {
+	if IsGroupingOpener(t) {
+		return true
+	}
 	switch t.(type) {
 	case *Literal, *CharacterSet, *Wildcard, *Digit, *AlphaNumeric,
-		*StartAnchor, *EndAnchor, *GroupingOpener:
+		*Whitespace, *NonDigit, *NonAlphaNumeric, *NonWhitespace,
+		*UnicodeProperty, *StartAnchor, *EndAnchor, *WordBoundary, *NonWordBoundary,
+		*BackReference, *NamedBackReference:
 		return true
 	default:
 		return false
@@ -51,7 +119,7 @@ func CanConcatenate(t Token) bool {
 
 func IsUnaryOperator(t Token) bool {
 	switch t.(type) {
-	case *OptionalQuantifier, *KleeneClosure, *PositiveClosure:
+	case *OptionalQuantifier, *KleeneClosure, *PositiveClosure, *BoundedRepetition:
 		return true
 	default:
 		return false
@@ -60,7 +128,9 @@ func IsUnaryOperator(t Token) bool {
 
 func IsAtom(t Token) bool {
 	switch t.(type) {
-	case *Literal, *CharacterSet, *Wildcard, *Digit, *AlphaNumeric:
+	case *Literal, *CharacterSet, *Wildcard, *Digit, *AlphaNumeric,
+		*Whitespace, *NonDigit, *NonAlphaNumeric, *NonWhitespace, *UnicodeProperty,
+		*BackReference, *NamedBackReference:
 		return true
 	default:
 		return false
@@ -69,44 +139,164 @@ func IsAtom(t Token) bool {
 
 // --- Token Interface and Structs ---
 
+// Span locates the text a token was read from: Length bytes of the pattern
+// starting at byte Offset.
+type Span struct {
+	Offset int
+	Length int
+}
+
+// End returns the offset just past the spanned text.
+func (s Span) End() int {
+	return s.Offset + s.Length
+}
+
 type Token interface {
 	getType() string
+	Span() Span
+	SetSpan(span Span)
 }
 
 type baseToken struct {
 	pType TokenType
+	span  Span
 }
 
 func (token *baseToken) getType() string {
 	return string(token.pType)
 }
 
+func (token *baseToken) Span() Span {
+	return token.span
+}
+
+func (token *baseToken) SetSpan(span Span) {
+	token.span = span
+}
+
 type Literal struct {
 	baseToken
 	Literal rune
 }
 type Digit struct{ baseToken }
 type AlphaNumeric struct{ baseToken }
+type Whitespace struct{ baseToken }
+type NonDigit struct{ baseToken }
+type NonAlphaNumeric struct{ baseToken }
+type NonWhitespace struct{ baseToken }
 type CharacterSet struct {
 	baseToken
 	IsPositive       bool
 	Literals         []rune
 	Ranges           [][2]rune
 	CharacterClasses []predefinedclass.PredefinedClass
+	Properties       []predefinedclass.UnicodeProperty
+}
+
+// UnicodeProperty is a standalone \p{...} or \P{...} class.
+type UnicodeProperty struct {
+	baseToken
+	Property predefinedclass.UnicodeProperty
 }
-type StartAnchor struct{ baseToken }
-type EndAnchor struct{ baseToken }
+
+// StartAnchor is '^', or '\A' when Absolute is set. An absolute anchor
+// matches only at the start of the input, regardless of the multiline flag.
+type StartAnchor struct {
+	baseToken
+	Absolute bool
+}
+
+// EndAnchor is '$', or '\z' when Absolute is set. '\Z' is an absolute
+// anchor that also matches before a newline ending the input.
+type EndAnchor struct {
+	baseToken
+	Absolute           bool
+	BeforeFinalNewline bool
+}
+type WordBoundary struct{ baseToken }
+type NonWordBoundary struct{ baseToken }
 type Wildcard struct{ baseToken }
 type GroupingOpener struct{ baseToken }
 type GroupingCloser struct{ baseToken }
 
+// NonCapturingGroupOpener opens a (?:...) group, which groups its contents
+// without recording a capture.
+type NonCapturingGroupOpener struct{ baseToken }
+
+// AtomicGroupOpener opens a (?>...) group, which commits to the first way
+// its contents match.
+type AtomicGroupOpener struct{ baseToken }
+
+// LookaroundOpener opens a lookahead ((?=...) or (?!...)) or, when Behind
+// is set, a lookbehind ((?<=...) or (?<!...)). Negated is set for the '!'
+// forms, which succeed when their contents do not match.
+type LookaroundOpener struct {
+	baseToken
+	Behind  bool
+	Negated bool
+}
+
+// SetFlags is an inline (?flags) modifier. It turns the On flags on and
+// the Off flags off until the end of the enclosing group.
+type SetFlags struct {
+	baseToken
+	On  Flags
+	Off Flags
+}
+
+// FlagGroupOpener opens a (?flags:...) group, which applies the flag
+// changes only to its own contents and does not capture.
+type FlagGroupOpener struct {
+	baseToken
+	On  Flags
+	Off Flags
+}
+
+// NamedGroupOpener opens a (?P<name>...) or (?<name>...) capture group.
+type NamedGroupOpener struct {
+	baseToken
+	Name string
+}
+
 type Concatenation struct{ baseToken }
-type KleeneClosure struct{ baseToken }
-type PositiveClosure struct{ baseToken }
-type OptionalQuantifier struct{ baseToken }
+
+// Quantifiers are greedy unless followed by '?', which makes them Lazy:
+// they then prefer to repeat as few times as possible. A '+' instead makes
+// them Possessive: they never give back what they matched.
+type KleeneClosure struct {
+	baseToken
+	Lazy       bool
+	Possessive bool
+}
+type PositiveClosure struct {
+	baseToken
+	Lazy       bool
+	Possessive bool
+}
+type OptionalQuantifier struct {
+	baseToken
+	Lazy       bool
+	Possessive bool
+}
+
+// BoundedRepetition is a counted quantifier such as {n}, {n,} or {n,m}.
+// Max is -1 when the repetition has no upper bound.
+type BoundedRepetition struct {
+	baseToken
+	Min        int
+	Max        int
+	Lazy       bool
+	Possessive bool
+}
 type Alternation struct{ baseToken }
 
 type BackReference struct {
 	baseToken
 	GroupIndex int
 }
+
+// NamedBackReference is a \k<name> reference to a named capture group.
+type NamedBackReference struct {
+	baseToken
+	Name string
+}
@@ -0,0 +1,219 @@
+package regex
+
+// submatchIndexes returns the positions of the whole match and of every
+// group for up to n successive matches of re in b, or all of them if n < 0.
+func (re *Regexp) submatchIndexes(b []byte, n int) [][]int {
+	if n == 0 {
+		return nil
+	}
+	var locations [][]int
+	for result := range re.matches(b) {
+		location := make([]int, 0, 2*len(result.Captures))
+		for _, capture := range result.Captures {
+			if capture.Start == -1 || capture.End == -1 {
+				location = append(location, -1, -1)
+				continue
+			}
+			location = append(location, capture.Start, capture.End)
+		}
+		locations = append(locations, location)
+		if len(locations) == n {
+			break
+		}
+	}
+	return locations
+}
+
+// FindIndex returns the position of the leftmost match of re in b, or nil
+// if there is none.
+func (re *Regexp) FindIndex(b []byte) []int {
+	locations := re.submatchIndexes(b, 1)
+	if locations == nil {
+		return nil
+	}
+	return locations[0][:2]
+}
+
+// FindStringIndex returns the position of the leftmost match of re in s,
+// or nil if there is none.
+func (re *Regexp) FindStringIndex(s string) []int {
+	return re.FindIndex([]byte(s))
+}
+
+// Find returns the text of the leftmost match of re in b, or nil if there
+// is none. An empty match returns an empty, non-nil slice.
+func (re *Regexp) Find(b []byte) []byte {
+	location := re.FindIndex(b)
+	if location == nil {
+		return nil
+	}
+	return b[location[0]:location[1]:location[1]]
+}
+
+// FindString returns the text of the leftmost match of re in s, or "" if
+// there is none. Use FindStringIndex to tell that apart from an empty
+// match.
+func (re *Regexp) FindString(s string) string {
+	location := re.FindStringIndex(s)
+	if location == nil {
+		return ""
+	}
+	return s[location[0]:location[1]]
+}
+
+// FindSubmatchIndex returns the positions of the leftmost match of re in b
+// and of its capture groups, or nil if there is no match.
+func (re *Regexp) FindSubmatchIndex(b []byte) []int {
+	locations := re.submatchIndexes(b, 1)
+	if locations == nil {
+		return nil
+	}
+	return locations[0]
+}
+
+// FindStringSubmatchIndex returns the positions of the leftmost match of
+// re in s and of its capture groups, or nil if there is no match.
+func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	return re.FindSubmatchIndex([]byte(s))
+}
+
+// FindSubmatch returns the text of the leftmost match of re in b and of
+// its capture groups, or nil if there is no match.
+func (re *Regexp) FindSubmatch(b []byte) [][]byte {
+	location := re.FindSubmatchIndex(b)
+	if location == nil {
+		return nil
+	}
+	submatches := make([][]byte, len(location)/2)
+	for i := range submatches {
+		if start, end := location[2*i], location[2*i+1]; start != -1 {
+			submatches[i] = b[start:end:end]
+		}
+	}
+	return submatches
+}
+
+// FindStringSubmatch returns the text of the leftmost match of re in s and
+// of its capture groups, or nil if there is no match. A group that did not
+// take part in the match is "".
+func (re *Regexp) FindStringSubmatch(s string) []string {
+	location := re.FindStringSubmatchIndex(s)
+	if location == nil {
+		return nil
+	}
+	submatches := make([]string, len(location)/2)
+	for i := range submatches {
+		if start, end := location[2*i], location[2*i+1]; start != -1 {
+			submatches[i] = s[start:end]
+		}
+	}
+	return submatches
+}
+
+// FindAllIndex returns the positions of up to n successive matches of re
+// in b, or nil if there is none.
+func (re *Regexp) FindAllIndex(b []byte, n int) [][]int {
+	locations := re.submatchIndexes(b, n)
+	for i, location := range locations {
+		locations[i] = location[:2]
+	}
+	return locations
+}
+
+// FindAllStringIndex returns the positions of up to n successive matches
+// of re in s, or nil if there is none.
+func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
+	return re.FindAllIndex([]byte(s), n)
+}
+
+// FindAll returns the text of up to n successive matches of re in b, or
+// nil if there is none.
+func (re *Regexp) FindAll(b []byte, n int) [][]byte {
+	locations := re.FindAllIndex(b, n)
+	if locations == nil {
+		return nil
+	}
+	matches := make([][]byte, len(locations))
+	for i, location := range locations {
+		matches[i] = b[location[0]:location[1]:location[1]]
+	}
+	return matches
+}
+
+// FindAllString returns the text of up to n successive matches of re in s,
+// or nil if there is none.
+func (re *Regexp) FindAllString(s string, n int) []string {
+	locations := re.FindAllStringIndex(s, n)
+	if locations == nil {
+		return nil
+	}
+	matches := make([]string, len(locations))
+	for i, location := range locations {
+		matches[i] = s[location[0]:location[1]]
+	}
+	return matches
+}
+
+// splitIndexes returns the positions of the pieces, as described by Split,
+// of a text of length textLength in which matches were found.
+func splitIndexes(matches [][]int, textLength, n int) [][]int {
+	if n == 0 {
+		return nil
+	}
+	if textLength == 0 {
+		return [][]int{{0, 0}}
+	}
+
+	var pieces [][]int
+	start, end := 0, 0
+	for _, match := range matches {
+		if n > 0 && len(pieces) == n-1 {
+			break
+		}
+		end = match[0]
+		if match[1] != 0 {
+			pieces = append(pieces, []int{start, end})
+		}
+		start = match[1]
+	}
+	if end != textLength {
+		pieces = append(pieces, []int{start, textLength})
+	}
+	return pieces
+}
+
+// Split slices b into the pieces between the successive matches of re.
+// An empty match at the very start or end of b does not produce an empty
+// piece before or after it, so that splitting "abc" on an empty pattern
+// gives "a", "b" and "c", while a non-empty match there does, so that
+// splitting ",a," on a comma gives "", "a" and "". An empty b is a single
+// empty piece.
+//
+// With n > 0, at most n pieces are returned and the last holds the rest of
+// b, matches included. With n == 0, Split returns nil, and with n < 0 it
+// returns every piece.
+func (re *Regexp) Split(b []byte, n int) [][]byte {
+	locations := splitIndexes(re.FindAllIndex(b, -1), len(b), n)
+	if locations == nil {
+		return nil
+	}
+	pieces := make([][]byte, len(locations))
+	for i, location := range locations {
+		pieces[i] = b[location[0]:location[1]:location[1]]
+	}
+	return pieces
+}
+
+// SplitString slices s into the pieces between the successive matches of
+// re, as Split does.
+func (re *Regexp) SplitString(s string, n int) []string {
+	locations := splitIndexes(re.FindAllStringIndex(s, -1), len(s), n)
+	if locations == nil {
+		return nil
+	}
+	pieces := make([]string, len(locations))
+	for i, location := range locations {
+		pieces[i] = s[location[0]:location[1]]
+	}
+	return pieces
+}
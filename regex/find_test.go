@@ -0,0 +1,236 @@
+package regex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindString(t *testing.T) {
+	tests := []struct {
+		pattern       string
+		text          string
+		expected      string
+		expectedIndex []int
+	}{
+		{pattern: `b+`, text: "abbbc", expected: "bbb", expectedIndex: []int{1, 4}},
+		{pattern: `x`, text: "abc", expected: "", expectedIndex: nil},
+		{pattern: `a*`, text: "baab", expected: "", expectedIndex: []int{0, 0}},
+		{pattern: `é+`, text: "caféé!", expected: "éé", expectedIndex: []int{3, 7}},
+		{pattern: `(\w)\1`, text: "abccd", expected: "cc", expectedIndex: []int{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if actual := re.FindString(tt.text); actual != tt.expected {
+				t.Errorf("FindString(%q) = %q, want %q", tt.text, actual, tt.expected)
+			}
+			if actual := re.FindStringIndex(tt.text); !reflect.DeepEqual(actual, tt.expectedIndex) {
+				t.Errorf("FindStringIndex(%q) = %v, want %v", tt.text, actual, tt.expectedIndex)
+			}
+			actual := re.Find([]byte(tt.text))
+			if (actual == nil) != (tt.expectedIndex == nil) || string(actual) != tt.expected {
+				t.Errorf("Find(%q) = %q, want %q", tt.text, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFindSubmatch(t *testing.T) {
+	tests := []struct {
+		pattern       string
+		text          string
+		expected      []string
+		expectedIndex []int
+	}{
+		{
+			pattern:       `(\d+)-(\d+)`,
+			text:          "call 555-1234 now",
+			expected:      []string{"555-1234", "555", "1234"},
+			expectedIndex: []int{5, 13, 5, 8, 9, 13},
+		},
+		{
+			pattern:       `(a)|(b)`,
+			text:          "xb",
+			expected:      []string{"b", "", "b"},
+			expectedIndex: []int{1, 2, -1, -1, 1, 2},
+		},
+		{
+			pattern:       `(?<word>\w+) \k<word>`,
+			text:          "it is is",
+			expected:      []string{"is is", "is"},
+			expectedIndex: []int{3, 8, 3, 5},
+		},
+		{
+			pattern:       `(x)`,
+			text:          "abc",
+			expected:      nil,
+			expectedIndex: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if actual := re.FindStringSubmatch(tt.text); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("FindStringSubmatch(%q) = %q, want %q", tt.text, actual, tt.expected)
+			}
+			if actual := re.FindStringSubmatchIndex(tt.text); !reflect.DeepEqual(actual, tt.expectedIndex) {
+				t.Errorf("FindStringSubmatchIndex(%q) = %v, want %v", tt.text, actual, tt.expectedIndex)
+			}
+		})
+	}
+}
+
+func TestFindSubmatchNonParticipatingGroup(t *testing.T) {
+	actual := MustCompile(`(a)|(b)`).FindSubmatch([]byte("b"))
+	if len(actual) != 3 || string(actual[0]) != "b" || actual[1] != nil || string(actual[2]) != "b" {
+		t.Errorf("FindSubmatch(%q) = %q, want [b <nil> b]", "b", actual)
+	}
+}
+
+func TestFindAllString(t *testing.T) {
+	tests := []struct {
+		name          string
+		pattern       string
+		text          string
+		n             int
+		expected      []string
+		expectedIndex [][]int
+	}{
+		{
+			name:          "successive matches",
+			pattern:       `\d+`,
+			text:          "a1b22c333",
+			n:             -1,
+			expected:      []string{"1", "22", "333"},
+			expectedIndex: [][]int{{1, 2}, {3, 5}, {6, 9}},
+		},
+		{
+			name:          "at most n matches",
+			pattern:       `\d+`,
+			text:          "a1b22c333",
+			n:             2,
+			expected:      []string{"1", "22"},
+			expectedIndex: [][]int{{1, 2}, {3, 5}},
+		},
+		{
+			name:          "zero matches requested",
+			pattern:       `\d+`,
+			text:          "a1b22c333",
+			n:             0,
+			expected:      nil,
+			expectedIndex: nil,
+		},
+		{
+			name:          "no match",
+			pattern:       `x`,
+			text:          "abc",
+			n:             -1,
+			expected:      nil,
+			expectedIndex: nil,
+		},
+		{
+			name:          "empty match right after a match is skipped",
+			pattern:       `a*`,
+			text:          "baab",
+			n:             -1,
+			expected:      []string{"", "aa", ""},
+			expectedIndex: [][]int{{0, 0}, {1, 3}, {4, 4}},
+		},
+		{
+			name:          "empty pattern advances by rune",
+			pattern:       ``,
+			text:          "éa",
+			n:             -1,
+			expected:      []string{"", "", ""},
+			expectedIndex: [][]int{{0, 0}, {2, 2}, {3, 3}},
+		},
+		{
+			name:          "empty pattern matches empty text",
+			pattern:       ``,
+			text:          "",
+			n:             -1,
+			expected:      []string{""},
+			expectedIndex: [][]int{{0, 0}},
+		},
+		{
+			name:          "never matches inside a rune",
+			pattern:       `[^é]`,
+			text:          "éaé",
+			n:             -1,
+			expected:      []string{"a"},
+			expectedIndex: [][]int{{2, 3}},
+		},
+		{
+			name:          "back-references",
+			pattern:       `(\w)\1`,
+			text:          "aabccdd",
+			n:             -1,
+			expected:      []string{"aa", "cc", "dd"},
+			expectedIndex: [][]int{{0, 2}, {3, 5}, {5, 7}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if actual := re.FindAllString(tt.text, tt.n); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("FindAllString(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+			}
+			if actual := re.FindAllStringIndex(tt.text, tt.n); !reflect.DeepEqual(actual, tt.expectedIndex) {
+				t.Errorf("FindAllStringIndex(%q, %d) = %v, want %v", tt.text, tt.n, actual, tt.expectedIndex)
+			}
+			actual := re.FindAll([]byte(tt.text), tt.n)
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("FindAll(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+			}
+			for i := range actual {
+				if string(actual[i]) != tt.expected[i] {
+					t.Errorf("FindAll(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestSplitString(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		text     string
+		n        int
+		expected []string
+	}{
+		{name: "every piece", pattern: `,`, text: "a,b,c", n: -1, expected: []string{"a", "b", "c"}},
+		{name: "at most n pieces", pattern: `,`, text: "a,b,c", n: 2, expected: []string{"a", "b,c"}},
+		{name: "one piece", pattern: `,`, text: "a,b,c", n: 1, expected: []string{"a,b,c"}},
+		{name: "no pieces", pattern: `,`, text: "a,b,c", n: 0, expected: nil},
+		{name: "matches at both ends", pattern: `,`, text: ",a,", n: -1, expected: []string{"", "a", ""}},
+		{name: "no match", pattern: `,`, text: "abc", n: -1, expected: []string{"abc"}},
+		{name: "empty pattern", pattern: ``, text: "abc", n: -1, expected: []string{"a", "b", "c"}},
+		{name: "empty pattern by rune", pattern: ``, text: "éa", n: -1, expected: []string{"é", "a"}},
+		{name: "empty matches between runs", pattern: `x*`, text: "axxb", n: -1, expected: []string{"a", "b"}},
+		{name: "empty text", pattern: `,`, text: "", n: -1, expected: []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if actual := re.SplitString(tt.text, tt.n); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("SplitString(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+			}
+			actual := re.Split([]byte(tt.text), tt.n)
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("Split(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+			}
+			for i := range actual {
+				if string(actual[i]) != tt.expected[i] {
+					t.Errorf("Split(%q, %d) = %q, want %q", tt.text, tt.n, actual, tt.expected)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Package regex compiles and matches regular expressions using the same
+// engine as mygrep. The syntax is the extended syntax described in the
+// project README, including back-references, lookaround and inline flags.
+//
+// A pattern is compiled into a non-deterministic finite automaton, which
+// is run by an NFA simulator, or by a backtracking engine when the pattern
+// contains back-references.
+//
+// The methods for finding matches come in pairs, one taking a []byte and
+// one, named with String, taking a string. Those named with Index return
+// the positions of matches as pairs of byte offsets, as in
+// b[loc[0]:loc[1]]. Those named with Submatch also return every capture
+// group, in order of group number after the whole match, with a nil slice,
+// "" or -1 offsets for a group that did not take part in the match.
+//
+// Those named with All return the successive non-overlapping matches in
+// the text, at most n of them when n >= 0. Each match is the leftmost one
+// starting at or after the point where the search resumes: the end of the
+// previous match or, after an empty match, one rune past it, so that the
+// search always progresses. An empty match right where the previous match
+// ended is skipped. For example, a* finds "", "aa" and "" in "baab": it
+// skips the empty match at index 3, where "aa" ended, and finds the last
+// one at the end of the text.
+package regex
+
+import (
+	"fmt"
+	"iter"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/ast"
+	"github.com/mmarchesotti/build-your-own-grep/internal/backtrack"
+	"github.com/mmarchesotti/build-your-own-grep/internal/buildnfa"
+	"github.com/mmarchesotti/build-your-own-grep/internal/lexer"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfa"
+	"github.com/mmarchesotti/build-your-own-grep/internal/nfasimulator"
+	"github.com/mmarchesotti/build-your-own-grep/internal/parser"
+)
+
+// Regexp is a compiled regular expression. It is never modified after
+// Compile returns, so it can be used by many goroutines at once.
+type Regexp struct {
+	expr         string
+	fragment     nfa.Fragment
+	captureCount int
+	groupNames   map[string]int
+	subexpNames  []string
+	simulate     func([]byte, nfa.Fragment, int, map[string]int) (iter.Seq[nfasimulator.MatchResult], error)
+}
+
+// Error is returned by Compile for an invalid pattern. Err holds every
+// problem found in it.
+type Error struct {
+	Expr string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("regex: invalid pattern %q: %v", e.Expr, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Compile parses expr and returns a Regexp that can be matched against
+// text, or an *Error if expr is invalid.
+func Compile(expr string) (*Regexp, error) {
+	tokens, err := lexer.Tokenize(expr)
+	if err != nil {
+		return nil, &Error{Expr: expr, Err: err}
+	}
+
+	tree, captureCount, groupNames, err := parser.Parse(tokens)
+	if err != nil {
+		return nil, &Error{Expr: expr, Err: err}
+	}
+
+	fragment, err := buildnfa.Build(tree)
+	if err != nil {
+		return nil, &Error{Expr: expr, Err: err}
+	}
+
+	subexpNames := make([]string, captureCount)
+	for name, groupIndex := range groupNames {
+		subexpNames[groupIndex] = name
+	}
+
+	re := &Regexp{
+		expr:         expr,
+		fragment:     fragment,
+		captureCount: captureCount,
+		groupNames:   groupNames,
+		subexpNames:  subexpNames,
+		simulate:     nfasimulator.Simulate,
+	}
+	if ast.HasBackReference(tree) {
+		re.simulate = backtrack.Simulate
+	}
+	return re, nil
+}
+
+// MustCompile is like Compile but panics if expr is invalid. It is meant
+// for patterns known to be valid, such as those in package variables.
+func MustCompile(expr string) *Regexp {
+	re, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// String returns the source text used to compile re.
+func (re *Regexp) String() string {
+	return re.expr
+}
+
+// NumSubexp returns the number of capture groups in re.
+func (re *Regexp) NumSubexp() int {
+	return re.captureCount - 1
+}
+
+// SubexpNames returns the names of the capture groups in re, indexed by
+// group number. Element 0, for the whole match, and the elements for
+// unnamed groups are empty strings. The slice must not be modified.
+func (re *Regexp) SubexpNames() []string {
+	return re.subexpNames
+}
+
+// Match reports whether b contains any match of re.
+func (re *Regexp) Match(b []byte) bool {
+	for range re.matches(b) {
+		return true
+	}
+	return false
+}
+
+// MatchString reports whether s contains any match of re.
+func (re *Regexp) MatchString(s string) bool {
+	return re.Match([]byte(s))
+}
+
+// matches returns the successive matches of re in b.
+func (re *Regexp) matches(b []byte) iter.Seq[nfasimulator.MatchResult] {
+	// Neither engine ever fails once the pattern has been built.
+	results, _ := re.simulate(b, re.fragment, re.captureCount, re.groupNames)
+	return results
+}
@@ -0,0 +1,113 @@
+package regex
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mmarchesotti/build-your-own-grep/internal/diagnostic"
+)
+
+func TestMatchString(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		text     string
+		expected bool
+	}{
+		{pattern: `abc`, text: "xabcx", expected: true},
+		{pattern: `abc`, text: "abx", expected: false},
+		{pattern: `^\d{3}-\d{4}$`, text: "555-1234", expected: true},
+		{pattern: `^\d{3}-\d{4}$`, text: "555-12345", expected: false},
+		{pattern: `(\w+) \1`, text: "hello hello", expected: true},
+		{pattern: `(\w+) \1`, text: "hello world", expected: false},
+		{pattern: `(?i)hello`, text: "HeLLo", expected: true},
+		{pattern: `(?<=\$)\d+`, text: "cost: $42", expected: true},
+		{pattern: `\p{Greek}+`, text: "abc", expected: false},
+		{pattern: ``, text: "", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re := MustCompile(tt.pattern)
+			if actual := re.MatchString(tt.text); actual != tt.expected {
+				t.Errorf("MatchString(%q) = %v, want %v", tt.text, actual, tt.expected)
+			}
+			if actual := re.Match([]byte(tt.text)); actual != tt.expected {
+				t.Errorf("Match(%q) = %v, want %v", tt.text, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile(`a(b\q`)
+	var compileErr *Error
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("Compile() returned %v, want an *Error", err)
+	}
+	if compileErr.Expr != `a(b\q` {
+		t.Errorf("Expr = %q, want %q", compileErr.Expr, `a(b\q`)
+	}
+	var patternErr *diagnostic.PatternError
+	if !errors.As(err, &patternErr) || patternErr.Code != diagnostic.CodeUnknownEscape {
+		t.Errorf("Compile() returned %v, want an unknown escape error", err)
+	}
+	expected := `regex: invalid pattern "a(b\\q": unknown escape sequence \q`
+	if err.Error() != expected {
+		t.Errorf("Error() = %q, want %q", err.Error(), expected)
+	}
+}
+
+func TestMustCompilePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustCompile did not panic for an invalid pattern")
+		}
+	}()
+	MustCompile(`a**`)
+}
+
+func TestSubexps(t *testing.T) {
+	re := MustCompile(`(?P<year>\d{4})-(\d{2})(?:-(?<day>\d{2}))?`)
+	if actual := re.NumSubexp(); actual != 3 {
+		t.Errorf("NumSubexp() = %d, want 3", actual)
+	}
+	expected := []string{"", "year", "", "day"}
+	if actual := re.SubexpNames(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("SubexpNames() = %q, want %q", actual, expected)
+	}
+	if actual := re.String(); actual != `(?P<year>\d{4})-(\d{2})(?:-(?<day>\d{2}))?` {
+		t.Errorf("String() = %q", actual)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	// One pattern for each engine: the back-reference is matched by the
+	// backtracking engine, the other by the NFA simulator.
+	backReference := MustCompile(`^(a+)b\1$`)
+	plain := MustCompile(`^a+b(a+)$`)
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				n := 1 + (i+j)%5
+				balanced := strings.Repeat("a", n) + "b" + strings.Repeat("a", n)
+				unbalanced := balanced[1:]
+				if !backReference.MatchString(balanced) || backReference.MatchString(unbalanced) {
+					t.Errorf("back-reference pattern gave a wrong result for %q or %q", balanced, unbalanced)
+					return
+				}
+				if !plain.MatchString(balanced) || plain.MatchString("b"+balanced) {
+					t.Errorf("plain pattern gave a wrong result for %q", balanced)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}